@@ -0,0 +1,241 @@
+package capabilities
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+ Root inside user namespace has full capability set in that namespace.
+ Same as docker we keep only small default set and let user adjust it with --cap-add/--cap-drop.
+ All capability sets are per thread, so Apply must run on the thread that later forks user command
+ (runtime.LockOSThread).
+ more about capabilities: man 7 capabilities
+*/
+
+// capability names and numbers, unknown to older kernels are ignored by Apply
+var capabilities = map[string]int{
+	"CAP_CHOWN":              unix.CAP_CHOWN,
+	"CAP_DAC_OVERRIDE":       unix.CAP_DAC_OVERRIDE,
+	"CAP_DAC_READ_SEARCH":    unix.CAP_DAC_READ_SEARCH,
+	"CAP_FOWNER":             unix.CAP_FOWNER,
+	"CAP_FSETID":             unix.CAP_FSETID,
+	"CAP_KILL":               unix.CAP_KILL,
+	"CAP_SETGID":             unix.CAP_SETGID,
+	"CAP_SETUID":             unix.CAP_SETUID,
+	"CAP_SETPCAP":            unix.CAP_SETPCAP,
+	"CAP_LINUX_IMMUTABLE":    unix.CAP_LINUX_IMMUTABLE,
+	"CAP_NET_BIND_SERVICE":   unix.CAP_NET_BIND_SERVICE,
+	"CAP_NET_BROADCAST":      unix.CAP_NET_BROADCAST,
+	"CAP_NET_ADMIN":          unix.CAP_NET_ADMIN,
+	"CAP_NET_RAW":            unix.CAP_NET_RAW,
+	"CAP_IPC_LOCK":           unix.CAP_IPC_LOCK,
+	"CAP_IPC_OWNER":          unix.CAP_IPC_OWNER,
+	"CAP_SYS_MODULE":         unix.CAP_SYS_MODULE,
+	"CAP_SYS_RAWIO":          unix.CAP_SYS_RAWIO,
+	"CAP_SYS_CHROOT":         unix.CAP_SYS_CHROOT,
+	"CAP_SYS_PTRACE":         unix.CAP_SYS_PTRACE,
+	"CAP_SYS_PACCT":          unix.CAP_SYS_PACCT,
+	"CAP_SYS_ADMIN":          unix.CAP_SYS_ADMIN,
+	"CAP_SYS_BOOT":           unix.CAP_SYS_BOOT,
+	"CAP_SYS_NICE":           unix.CAP_SYS_NICE,
+	"CAP_SYS_RESOURCE":       unix.CAP_SYS_RESOURCE,
+	"CAP_SYS_TIME":           unix.CAP_SYS_TIME,
+	"CAP_SYS_TTY_CONFIG":     unix.CAP_SYS_TTY_CONFIG,
+	"CAP_MKNOD":              unix.CAP_MKNOD,
+	"CAP_LEASE":              unix.CAP_LEASE,
+	"CAP_AUDIT_WRITE":        unix.CAP_AUDIT_WRITE,
+	"CAP_AUDIT_CONTROL":      unix.CAP_AUDIT_CONTROL,
+	"CAP_SETFCAP":            unix.CAP_SETFCAP,
+	"CAP_MAC_OVERRIDE":       unix.CAP_MAC_OVERRIDE,
+	"CAP_MAC_ADMIN":          unix.CAP_MAC_ADMIN,
+	"CAP_SYSLOG":             unix.CAP_SYSLOG,
+	"CAP_WAKE_ALARM":         unix.CAP_WAKE_ALARM,
+	"CAP_BLOCK_SUSPEND":      unix.CAP_BLOCK_SUSPEND,
+	"CAP_AUDIT_READ":         unix.CAP_AUDIT_READ,
+	"CAP_PERFMON":            unix.CAP_PERFMON,
+	"CAP_BPF":                unix.CAP_BPF,
+	"CAP_CHECKPOINT_RESTORE": unix.CAP_CHECKPOINT_RESTORE,
+}
+
+// DefaultCaps is the same set docker gives to containers
+var DefaultCaps = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FSETID",
+	"CAP_FOWNER",
+	"CAP_MKNOD",
+	"CAP_NET_RAW",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETFCAP",
+	"CAP_SETPCAP",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_SYS_CHROOT",
+	"CAP_KILL",
+	"CAP_AUDIT_WRITE",
+}
+
+// normalizes name like sys_admin into CAP_SYS_ADMIN
+func canonical(name string) (string, error) {
+	name = strings.ToUpper(name)
+	if name == "ALL" {
+		return name, nil
+	}
+	if !strings.HasPrefix(name, "CAP_") {
+		name = "CAP_" + name
+	}
+	if _, ok := capabilities[name]; !ok {
+		return "", fmt.Errorf("unknown capability %q", name)
+	}
+	return name, nil
+}
+
+// Merge returns defaults with add and drop applied. Both can contain ALL, drop is applied first
+func Merge(defaults, add, drop []string) ([]string, error) {
+	set := make(map[string]bool)
+	for _, c := range defaults {
+		set[c] = true
+	}
+	for _, c := range drop {
+		name, err := canonical(c)
+		if err != nil {
+			return nil, err
+		}
+		if name == "ALL" {
+			set = make(map[string]bool)
+			continue
+		}
+		delete(set, name)
+	}
+	for _, c := range add {
+		name, err := canonical(c)
+		if err != nil {
+			return nil, err
+		}
+		if name == "ALL" {
+			for n := range capabilities {
+				set[n] = true
+			}
+			continue
+		}
+		set[name] = true
+	}
+	var caps []string
+	for c := range set {
+		caps = append(caps, c)
+	}
+	sort.Strings(caps)
+	return caps, nil
+}
+
+// Sets holds every capability set of process separately, as OCI runtime spec has them
+type Sets struct {
+	Bounding    []string
+	Effective   []string
+	Permitted   []string
+	Inheritable []string
+	Ambient     []string
+}
+
+// Apply sets bounding, effective, permitted, inheritable and ambient sets
+// of calling thread to given capabilities
+func Apply(caps []string) error {
+	return ApplySets(Sets{Bounding: caps, Effective: caps, Permitted: caps, Inheritable: caps, Ambient: caps})
+}
+
+// ApplySets sets capability sets of calling thread. Kernel refuses effective and inheritable
+// capabilities missing in permitted set and ambient ones missing in permitted or inheritable
+func ApplySets(sets Sets) error {
+	lastCap, err := lastCap()
+	if err != nil {
+		return err
+	}
+	bounding := make(map[int]bool)
+	for _, c := range sets.Bounding {
+		bounding[capabilities[c]] = true
+	}
+
+	// bounding set limits what can be gained later by executing setuid or file capability binaries
+	for c := 0; c <= lastCap; c++ {
+		if bounding[c] {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil {
+			return fmt.Errorf("dropping %d from bounding set: %s", c, err)
+		}
+	}
+
+	// version 3 uses two 32 bit words for each set
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	for _, set := range []struct {
+		names []string
+		mask  func(d *unix.CapUserData) *uint32
+	}{
+		{sets.Effective, func(d *unix.CapUserData) *uint32 { return &d.Effective }},
+		{sets.Permitted, func(d *unix.CapUserData) *uint32 { return &d.Permitted }},
+		{sets.Inheritable, func(d *unix.CapUserData) *uint32 { return &d.Inheritable }},
+	} {
+		for _, name := range set.names {
+			if c := capabilities[name]; c <= lastCap {
+				*set.mask(&data[c/32]) |= 1 << uint(c%32)
+			}
+		}
+	}
+	if err := unix.Capset(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("capset: %s", err)
+	}
+
+	// ambient set keeps capabilities over exec of non root user
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return fmt.Errorf("clearing ambient set: %s", err)
+	}
+	for _, name := range sets.Ambient {
+		c := capabilities[name]
+		if c > lastCap {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE, uintptr(c), 0, 0); err != nil {
+			return fmt.Errorf("raising %d in ambient set: %s", c, err)
+		}
+	}
+	return nil
+}
+
+// HasEffective tells if capability is in effective set of calling thread
+func HasEffective(name string) (bool, error) {
+	name, err := canonical(name)
+	if err != nil {
+		return false, err
+	}
+	c, ok := capabilities[name]
+	if !ok {
+		return false, fmt.Errorf("can't check %s", name)
+	}
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return false, fmt.Errorf("capget: %s", err)
+	}
+	return data[c/32].Effective&(1<<uint(c%32)) != 0, nil
+}
+
+// SetNoNewPrivs makes sure setuid binaries and file capabilities can't give more privileges
+func SetNoNewPrivs() error {
+	return unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0)
+}
+
+// highest capability number known to running kernel
+func lastCap() (int, error) {
+	content, err := ioutil.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(content)))
+}
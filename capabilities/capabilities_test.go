@@ -0,0 +1,37 @@
+package capabilities
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	defaults := []string{"CAP_CHOWN", "CAP_KILL", "CAP_NET_RAW"}
+
+	var cases = []struct {
+		add, drop []string
+		ans       []string
+	}{
+		{nil, nil, []string{"CAP_CHOWN", "CAP_KILL", "CAP_NET_RAW"}},
+		{[]string{"sys_admin"}, []string{"NET_RAW"}, []string{"CAP_CHOWN", "CAP_KILL", "CAP_SYS_ADMIN"}},
+		{[]string{"CAP_KILL"}, []string{"all"}, []string{"CAP_KILL"}},
+		{nil, []string{"ALL"}, nil},
+	}
+	for _, c := range cases {
+		resp, err := Merge(defaults, c.add, c.drop)
+		if err != nil {
+			t.Error("Got error: ", err)
+		}
+		if !reflect.DeepEqual(resp, c.ans) {
+			t.Errorf("For add %v drop %v expecting %v, got %v", c.add, c.drop, c.ans, resp)
+		}
+	}
+
+	all, err := Merge(nil, []string{"ALL"}, nil)
+	if err != nil || len(all) != len(capabilities) {
+		t.Errorf("Expecting all %d capabilities, got %d (%v)", len(capabilities), len(all), err)
+	}
+	if _, err := Merge(defaults, []string{"CAP_FLY"}, nil); err == nil {
+		t.Error("Expecting error for unknown capability")
+	}
+}
@@ -0,0 +1,218 @@
+package cgroup
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+ All containers get their own cgroup v2 directory:
+ -cgroupRoot
+ |-cntcli			<- parent group, has controllers enabled for children
+ ||-<container_name>		<- limits are written here, container pid is placed here
+ more about cgroup v2: https://www.kernel.org/doc/Documentation/cgroup-v2.txt
+*/
+var (
+	cgroupRoot = "/sys/fs/cgroup"
+	parentName = "cntcli"
+)
+
+// controllers we need enabled for container groups
+var controllers = []string{"cpu", "memory", "pids", "io"}
+
+// Resources holds limits applied to container cgroup. Zero value means no limit
+type Resources struct {
+	// bytes
+	Memory int64
+	// memory + swap in bytes like docker --memory-swap, -1 means unlimited swap
+	MemorySwap int64
+	// number of CPUs, can be fractional
+	CPUs float64
+	// relative weight as in docker --cpu-shares (2-262144, default 1024)
+	CPUShares uint64
+	PidsLimit int64
+	// io.weight (1-10000, default 100)
+	IOWeight uint64
+}
+
+// Path returns cgroup directory of given container
+func Path(containerName string) string {
+	return filepath.Join(cgroupRoot, parentName, containerName)
+}
+
+// Supported checks if unified cgroup v2 hierarchy is mounted
+func Supported() bool {
+	var st unix.Statfs_t
+	if err := unix.Statfs(cgroupRoot, &st); err != nil {
+		return false
+	}
+	return st.Type == unix.CGROUP2_SUPER_MAGIC
+}
+
+// Create makes cgroup for container and applies limits to it
+func Create(containerName string, res *Resources) error {
+	if !Supported() {
+		return errors.New("cgroup v2 not mounted at " + cgroupRoot)
+	}
+	parent := filepath.Join(cgroupRoot, parentName)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	// controllers must be enabled on every level above container group
+	enableControllers(cgroupRoot)
+	enableControllers(parent)
+
+	if err := os.Mkdir(Path(containerName), 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	if err := applyResources(Path(containerName), res); err != nil {
+		Remove(containerName)
+		return err
+	}
+	return nil
+}
+
+// Open returns cgroup directory handle, it can be used as SysProcAttr.CgroupFD
+// so child starts directly inside container cgroup
+func Open(containerName string) (*os.File, error) {
+	return os.Open(Path(containerName))
+}
+
+// AddProcess moves process into container cgroup
+func AddProcess(containerName string, pid int) error {
+	return writeFile(Path(containerName), "cgroup.procs", strconv.Itoa(pid))
+}
+
+// Remove kills whatever is left in container cgroup and deletes it
+func Remove(containerName string) error {
+	path := Path(containerName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	// cgroup.kill is available since 5.14, on older kernels we just hope group is empty
+	writeFile(path, "cgroup.kill", "1")
+
+	// killed processes need a moment to leave the group
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = os.Remove(path); err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return err
+}
+
+// best effort, controller can be missing on this kernel or not delegated to us.
+// Missing controller shows up as error when limit is written.
+func enableControllers(path string) {
+	for _, c := range controllers {
+		writeFile(path, "cgroup.subtree_control", "+"+c)
+	}
+}
+
+func applyResources(path string, res *Resources) error {
+	if res == nil {
+		return nil
+	}
+	if res.MemorySwap != 0 && res.Memory == 0 {
+		return errors.New("memory swap limit requires memory limit to be set")
+	}
+	if res.Memory > 0 {
+		if err := writeFile(path, "memory.max", strconv.FormatInt(res.Memory, 10)); err != nil {
+			return err
+		}
+		// cgroup v2 limits swap alone, docker style limit is memory + swap
+		// not set means swap equal to memory, same as docker
+		swap := "max"
+		switch {
+		case res.MemorySwap == 0:
+			swap = strconv.FormatInt(res.Memory, 10)
+		case res.MemorySwap > 0:
+			if res.MemorySwap < res.Memory {
+				return errors.New("memory swap limit must be bigger than memory limit")
+			}
+			swap = strconv.FormatInt(res.MemorySwap-res.Memory, 10)
+		}
+		if err := writeFile(path, "memory.swap.max", swap); err != nil {
+			return err
+		}
+	}
+	if res.CPUs > 0 {
+		period := 100000
+		quota := int(res.CPUs * float64(period))
+		if err := writeFile(path, "cpu.max", fmt.Sprintf("%d %d", quota, period)); err != nil {
+			return err
+		}
+	}
+	if res.CPUShares > 0 {
+		if err := writeFile(path, "cpu.weight", strconv.FormatUint(SharesToWeight(res.CPUShares), 10)); err != nil {
+			return err
+		}
+	}
+	if res.PidsLimit > 0 {
+		if err := writeFile(path, "pids.max", strconv.FormatInt(res.PidsLimit, 10)); err != nil {
+			return err
+		}
+	}
+	if res.IOWeight > 0 {
+		if res.IOWeight > 10000 {
+			return errors.New("io weight must be in range 1-10000")
+		}
+		if err := writeFile(path, "io.weight", "default "+strconv.FormatUint(res.IOWeight, 10)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SharesToWeight converts cgroup v1 cpu.shares (2-262144) into v2 cpu.weight (1-10000).
+// Same formula as runc uses.
+func SharesToWeight(shares uint64) uint64 {
+	if shares < 2 {
+		shares = 2
+	}
+	if shares > 262144 {
+		shares = 262144
+	}
+	return 1 + ((shares-2)*9999)/262142
+}
+
+// ParseSize converts human readable size like 512m or 1g into bytes
+func ParseSize(size string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(size))
+	s = strings.TrimSuffix(s, "b")
+	multiplier := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k':
+			multiplier = 1 << 10
+		case 'm':
+			multiplier = 1 << 20
+		case 'g':
+			multiplier = 1 << 30
+		case 't':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+func writeFile(path, file, value string) error {
+	return ioutil.WriteFile(filepath.Join(path, file), []byte(value), 0644)
+}
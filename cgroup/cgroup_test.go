@@ -0,0 +1,43 @@
+package cgroup
+
+import (
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	var cases = map[string]int64{
+		"1024":  1024,
+		"1k":    1024,
+		"512m":  512 << 20,
+		"512MB": 512 << 20,
+		"1g":    1 << 30,
+		"1.5g":  3 << 29,
+	}
+	for param, ans := range cases {
+		resp, err := ParseSize(param)
+		if err != nil {
+			t.Error("Got error: ", err)
+		}
+		if resp != ans {
+			t.Errorf("For %s expecting %d, got %d", param, ans, resp)
+		}
+	}
+	for _, param := range []string{"", "m", "-1m", "ten"} {
+		if _, err := ParseSize(param); err == nil {
+			t.Errorf("For %s expecting error", param)
+		}
+	}
+}
+
+func TestSharesToWeight(t *testing.T) {
+	var cases = map[uint64]uint64{
+		2:      1,
+		1024:   39,
+		262144: 10000,
+	}
+	for param, ans := range cases {
+		if resp := SharesToWeight(param); resp != ans {
+			t.Errorf("For %d expecting %d, got %d", param, ans, resp)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package cgroup
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// how long we wait for kernel to report all processes frozen or thawed
+var freezeTimeout = 10 * time.Second
+
+// Freeze stops all processes of container using cgroup v2 freezer
+func Freeze(containerName string) error {
+	return setFrozen(containerName, true)
+}
+
+// Thaw resumes processes of frozen container
+func Thaw(containerName string) error {
+	return setFrozen(containerName, false)
+}
+
+// writing cgroup.freeze only starts the process, cgroup.events reports when it is done
+func setFrozen(containerName string, frozen bool) error {
+	path := Path(containerName)
+	value := "0"
+	if frozen {
+		value = "1"
+	}
+	if err := writeFile(path, "cgroup.freeze", value); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(freezeTimeout)
+	for time.Now().Before(deadline) {
+		state, err := readEvent(path, "frozen")
+		if err != nil {
+			return err
+		}
+		if state == value {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("timeout waiting for %s to become frozen=%s", containerName, value)
+}
+
+// returns value of key from cgroup.events
+func readEvent(path, key string) (string, error) {
+	f, err := os.Open(filepath.Join(path, "cgroup.events"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == key {
+			return fields[1], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%s not found in cgroup.events", key)
+}
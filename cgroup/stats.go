@@ -0,0 +1,112 @@
+package cgroup
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Stats holds resource usage read from container cgroup
+type Stats struct {
+	MemoryCurrent uint64
+	// 0 means no limit
+	MemoryLimit uint64
+	// content of memory.stat (anon, file, kernel_stack...)
+	MemoryStat map[string]uint64
+	// content of cpu.stat (usage_usec, user_usec, system_usec, nr_throttled...)
+	CPUStat     map[string]uint64
+	PidsCurrent uint64
+	// io.stat per device major:minor (rbytes, wbytes, rios, wios...)
+	IOStat map[string]map[string]uint64
+}
+
+// GetStats reads current resource usage of container
+func GetStats(containerName string) (*Stats, error) {
+	path := Path(containerName)
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	var (
+		stats = &Stats{}
+		err   error
+	)
+	// controller files can be missing if controller wasn't enabled, such values stay empty
+	stats.MemoryCurrent, _ = readUint(path, "memory.current")
+	stats.MemoryLimit, _ = readUint(path, "memory.max")
+	stats.PidsCurrent, _ = readUint(path, "pids.current")
+	if stats.MemoryStat, err = readFlatKeyed(path, "memory.stat"); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if stats.CPUStat, err = readFlatKeyed(path, "cpu.stat"); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if stats.IOStat, err = readNestedKeyed(path, "io.stat"); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// single value files, "max" is returned as 0
+func readUint(path, file string) (uint64, error) {
+	content, err := ioutil.ReadFile(filepath.Join(path, file))
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// files in "key value" per line format
+func readFlatKeyed(path, file string) (map[string]uint64, error) {
+	f, err := os.Open(filepath.Join(path, file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = v
+		}
+	}
+	return values, scanner.Err()
+}
+
+// files in "key subkey=value subkey=value" per line format
+func readNestedKeyed(path, file string) (map[string]map[string]uint64, error) {
+	f, err := os.Open(filepath.Join(path, file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := make(map[string]map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		values[fields[0]] = make(map[string]uint64)
+		for _, kv := range fields[1:] {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			if v, err := strconv.ParseUint(parts[1], 10, 64); err == nil {
+				values[fields[0]][parts[0]] = v
+			}
+		}
+	}
+	return values, scanner.Err()
+}
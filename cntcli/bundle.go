@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/odk-/dockerinternals/container"
+	"github.com/odk-/dockerinternals/oci"
+	"github.com/odk-/dockerinternals/registry"
+	"github.com/odk-/dockerinternals/storage"
+)
+
+const bundleUsage = "usage: bundle [-copy] <image> <dir>"
+
+/*
+ bundle [-copy] <image> <dir> writes OCI bundle of image for runc, crun or our oci command.
+ By default config.json points to image overlay mounted in containers/bundle-<name> (name from -n,
+ or bundle directory name), -copy puts standalone copy of rootfs into bundle instead.
+ Overlay has its own directory so running bundle as container of the same name can't touch it.
+*/
+func bundleCommand(args []string) (err error) {
+	fs := flag.NewFlagSet("bundle", flag.ExitOnError)
+	copyRootfs := fs.Bool("copy", false, "copy rootfs into bundle instead of pointing to mounted image")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return errors.New(bundleUsage)
+	}
+	image, dir := fs.Arg(0), fs.Arg(1)
+	// overlay can be mounted on host only by root
+	if os.Geteuid() != 0 {
+		return errors.New("bundle needs root, rootless image overlay is mounted only inside container")
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(dir, "config.json")); err == nil {
+		return fmt.Errorf("%s already contains config.json", dir)
+	}
+	name := *containerName
+	if name == "" {
+		name = filepath.Base(dir)
+	}
+
+	// same ownership of files as in containers we run, bundle gets the same mappings
+	uids, gids, err := container.DefaultIDMappings()
+	if err != nil {
+		return err
+	}
+	storage.SetIDMappings(uids, gids)
+	mountName := "bundle-" + name
+	rootfs, err := container.DownloadAndMount(image, mountName)
+	if err != nil {
+		return err
+	}
+	// half written bundle is useless, it must not keep image mounted either
+	defer func() {
+		if err == nil {
+			return
+		}
+		if err := syscall.Unmount(rootfs, 0); err != nil {
+			log.Println("Unmounting bundle rootfs failed: ", err)
+		}
+		os.RemoveAll(storage.ContainerPath(mountName))
+		if *copyRootfs {
+			os.RemoveAll(filepath.Join(dir, "rootfs"))
+		}
+	}()
+	img, err := registry.ParseImageName(image)
+	if err != nil {
+		return err
+	}
+	var imgConfig *registry.ContainerConfig
+	if c, err := storage.GetImageConfig(img); err != nil {
+		log.Println("Image config not available, bundle runs /bin/sh as root: ", err)
+	} else {
+		imgConfig = &c.Config
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	rootPath := rootfs
+	if *copyRootfs {
+		rootPath = "rootfs"
+		if err := storage.CopyRootFS(rootfs, filepath.Join(dir, rootPath)); err != nil {
+			return err
+		}
+	}
+	spec, err := oci.Generate(imgConfig, rootfs, rootPath, name, uids, gids)
+	if err != nil {
+		return err
+	}
+	j, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), j, 0644); err != nil {
+		return err
+	}
+
+	// copy doesn't need image mounted anymore
+	if *copyRootfs {
+		if err := syscall.Unmount(rootfs, 0); err != nil {
+			return err
+		}
+		return os.RemoveAll(storage.ContainerPath(mountName))
+	}
+	log.Println("Bundle rootfs stays mounted at: ", rootfs)
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+)
+
+// subcommands available next to default "run container" mode
+var commands = map[string]func(args []string) error{
+	"volume":  volumeCommand,
+	"stats":   statsCommand,
+	"ps":      psCommand,
+	"rm":      rmCommand,
+	"pause":   pauseCommand,
+	"unpause": unpauseCommand,
+	"oci":     ociCommand,
+	"bundle":  bundleCommand,
+	"network": networkCommand,
+}
+
+func runCommand(args []string) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return cmd(args[1:])
+}
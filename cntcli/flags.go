@@ -0,0 +1,15 @@
+package main
+
+import "strings"
+
+// stringList collects every occurrence of a repeatable flag like --dns
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
@@ -4,7 +4,6 @@ import (
 	"flag"
 	"log"
 	"os"
-	"syscall"
 
 	"github.com/odk-/dockerinternals/container"
 	"github.com/odk-/dockerinternals/network"
@@ -19,7 +18,38 @@ var storageRootPath = flag.String("d", "", "location of image and container file
 var imageName = flag.String("i", "", "name of image to run. Docker naming compatible [required].")
 var insecureRegistry = flag.Bool("http", false, "If set registry will use http [optional].")
 var fsOnly = flag.Bool("o", false, "If set do not start container. Only download and mount FS")
-var command = flag.String("c", "/bin/sh", "Command to run")
+var command = flag.String("c", "/bin/sh", "Command to run with its arguments, quoted as in shell")
+var hostname = flag.String("hostname", "", "container hostname. Defaults to container name [optional].")
+var dnsServers, extraHosts, volumes, tmpfs, securityOpts, capAdd, capDrop, uidMaps, gidMaps, envVars, publish stringList
+var user = flag.String("user", "", "user[:group] to run command as, names are resolved in container. Defaults to image user [optional].")
+var workdir = flag.String("workdir", "", "working directory of command. Defaults to image one or / [optional].")
+var networkName = flag.String("network", network.DefaultNetwork, "network to connect container to (see network ls), none, host or container:<name> [optional].")
+var ipAddress = flag.String("ip", "", "container address from network subnet, with or without /prefix. Defaults to first free one [optional].")
+var ip6Address = flag.String("ip6", "", "container IPv6 address from subnet of dual-stack network, with or without /prefix. Defaults to first free one [optional].")
+var readOnly = flag.Bool("read-only", false, "mount container root filesystem read-only [optional].")
+var memory = flag.String("memory", "", "memory limit like 512m or 1g [optional].")
+var memorySwap = flag.String("memory-swap", "", "memory plus swap limit, -1 for unlimited swap [optional].")
+var cpus = flag.Float64("cpus", 0, "number of CPUs container can use, can be fractional [optional].")
+var cpuShares = flag.Uint64("cpu-shares", 0, "relative CPU weight, default 1024 [optional].")
+var pidsLimit = flag.Int64("pids-limit", 0, "maximum number of processes in container [optional].")
+var ioWeight = flag.Uint64("io-weight", 0, "relative io weight in range 1-10000, default 100 [optional].")
+
+func init() {
+	flag.StringVar(user, "u", "", "shorthand for --user.")
+	flag.StringVar(workdir, "w", "", "shorthand for --workdir.")
+	flag.Var(&envVars, "env", "KEY=value environment variable of command, overrides image one, can be repeated [optional].")
+	flag.Var(&envVars, "e", "shorthand for --env.")
+	flag.Var(&dnsServers, "dns", "nameserver for container resolv.conf, can be repeated [optional].")
+	flag.Var(&extraHosts, "add-host", "additional host:ip entry for container /etc/hosts, can be repeated [optional].")
+	flag.Var(&tmpfs, "tmpfs", "mount tmpfs at /path[:size=64m,mode=1777,...], can be repeated [optional].")
+	flag.Var(&securityOpts, "security-opt", "security option: seccomp=profile.json, seccomp=unconfined, no-new-privileges=false or systempaths=unconfined, can be repeated [optional].")
+	flag.Var(&capAdd, "cap-add", "add Linux capability, ALL for all of them, can be repeated [optional].")
+	flag.Var(&capDrop, "cap-drop", "drop Linux capability, ALL for all of them, can be repeated [optional].")
+	flag.Var(&uidMaps, "uidmap", "containerID:hostID:size user namespace uid mapping, replaces ranges from /etc/subuid, can be repeated [optional].")
+	flag.Var(&gidMaps, "gidmap", "containerID:hostID:size user namespace gid mapping, replaces ranges from /etc/subgid, can be repeated [optional].")
+	flag.Var(&publish, "p", "publish container port on host as hostPort:containerPort[/tcp|udp], can be repeated [optional].")
+	flag.Var(&volumes, "v", "bind mount host:container[:ro] or named volume name:container[:ro], can be repeated [optional].")
+}
 
 func init() {
 	reexec.Register("nsInit", nsInit)
@@ -33,60 +63,54 @@ func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	// parse flags and check if all required info was provided
 	flag.Parse()
-	if *containerName == "" || *imageName == "" {
-		flag.Usage()
-		os.Exit(1)
-	}
 
-	// initialize storage
+	// initialize storage, unprivileged user keeps it in home directory
+	rootless := os.Geteuid() != 0
 	if *storageRootPath != "" {
 		storage.SetStorageRootPath(*storageRootPath)
+	} else if rootless {
+		storage.SetStorageRootPath(storage.RootlessStorageRootPath())
 	}
+	storage.SetRootless(rootless)
+	network.SetConfigPath(storage.NetworksPath())
 	registry.InsecureRegistry(*insecureRegistry)
 	err := storage.InitStorage()
 	if err != nil {
 		log.Println(err)
 	}
 
-	if *fsOnly {
-		newRoot, err := container.DownloadAndMount(*imageName, *containerName)
-		if err != nil {
+	// anything left after flags is a subcommand like "volume ls"
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
 			log.Println(err)
-			os.Exit(1)
+			os.Exit(exitCode(err))
 		}
-		log.Println("Container root path: ", newRoot)
+		return
+	}
 
-	} else {
+	if *containerName == "" || *imageName == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *hostname == "" {
+		*hostname = *containerName
+	}
 
-		cmd, newRoot, err := container.SetNameSpaces(*imageName, *containerName, *command)
+	if *fsOnly {
+		newRoot, err := container.DownloadAndMount(*imageName, *containerName)
 		if err != nil {
 			log.Println(err)
 			os.Exit(1)
 		}
-
-		// do the clean unmount on exit
-		defer unmount(newRoot)
-
-		if err := cmd.Start(); err != nil {
-			log.Printf("Error starting the reexec.Command - %s\n", err)
-			os.Exit(1)
+		log.Println("Container root path: ", newRoot)
+		if rootless {
+			log.Println("Running rootless, overlay gets mounted only inside container namespace")
 		}
 
-		log.Println("pid: ", cmd.Process.Pid)
-		err = network.Setup(cmd.Process.Pid)
-		if err != nil {
+	} else {
+		if err := runContainer(); err != nil {
 			log.Println(err)
-			os.Exit(1)
-		}
-
-		if err := cmd.Wait(); err != nil {
-			log.Printf("Error waiting for the reexec.Command - %s\n", err)
-			os.Exit(1)
+			os.Exit(exitCode(err))
 		}
 	}
-
-}
-
-func unmount(path string) error {
-	return syscall.Unmount(path, 0)
 }
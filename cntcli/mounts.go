@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/odk-/dockerinternals/container"
+
+	"golang.org/x/sys/unix"
+)
+
+// make all mounts in our namespace private, so nothing we mount propagates back to host
+// and host mount events don't show up inside container
+func makeRootPrivate() error {
+	return syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, "")
+}
+
+// bind mount volumes into rootfs. Must be called before pivotRoot as sources live on host fs
+func mountVolumes(newroot string, mounts []container.Mount) error {
+	for _, m := range mounts {
+		fi, err := os.Stat(m.Source)
+		if err != nil {
+			return err
+		}
+		// bind mount target must exist and be of the same type as source
+		target, err := mountTarget(newroot, m.Destination, fi.IsDir())
+		if err != nil {
+			return err
+		}
+
+		if err := syscall.Mount(m.Source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return err
+		}
+		if m.ReadOnly {
+			if err := remountReadOnly(target); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolves destination inside rootfs, symlinks of image can't point it to host,
+// and creates it as directory or empty file
+func mountTarget(newroot, destination string, dir bool) (string, error) {
+	target, err := container.SecureJoin(newroot, destination)
+	if err != nil {
+		return "", err
+	}
+	if dir {
+		return target, os.MkdirAll(target, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+	return target, f.Close()
+}
+
+/*
+ Bind mounts ignore MS_RDONLY on first mount so read-only needs a remount.
+ Inside user namespace kernel locks nosuid, nodev, noexec and atime flags of
+ mounts inherited from host, remount without them fails with EPERM so we copy them over.
+*/
+func remountReadOnly(target string) error {
+	var st unix.Statfs_t
+	if err := unix.Statfs(target, &st); err != nil {
+		return err
+	}
+	locked := uintptr(st.Flags) & (unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC |
+		unix.MS_NOATIME | unix.MS_NODIRATIME | unix.MS_RELATIME)
+	return syscall.Mount("", target, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|locked, "")
+}
+
+// tmpfs mounts give writable scratch space even with read-only rootfs
+func mountTmpfs(newroot string, mounts []container.Tmpfs) error {
+	for _, t := range mounts {
+		target, err := mountTarget(newroot, t.Destination, true)
+		if err != nil {
+			return err
+		}
+		if err := syscall.Mount("tmpfs", target, "tmpfs", t.Flags, t.Data); err != nil {
+			return fmt.Errorf("%s: %s", t.Destination, err)
+		}
+	}
+	return nil
+}
+
+// mounts filesystems other than bind and tmpfs. /proc is mounted by mountProc already.
+// Fresh /dev gets the usual devices bind mounted from host, we can't mknod in user namespace
+func mountFs(newroot string, mounts []container.FsMount) error {
+	for _, m := range mounts {
+		if m.Type == "proc" && m.Destination == "/proc" {
+			continue
+		}
+		target, err := mountTarget(newroot, m.Destination, true)
+		if err != nil {
+			return err
+		}
+		if err := syscall.Mount(m.Source, target, m.Type, m.Flags, m.Data); err != nil {
+			return fmt.Errorf("%s: %s", m.Destination, err)
+		}
+		if m.Destination == "/dev" {
+			if err := populateDev(target); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// default devices and links every container expects in /dev
+func populateDev(dev string) error {
+	for _, name := range []string{"null", "zero", "full", "random", "urandom", "tty"} {
+		target := filepath.Join(dev, name)
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			return err
+		}
+		f.Close()
+		if err := syscall.Mount("/dev/"+name, target, "", syscall.MS_BIND, ""); err != nil {
+			return fmt.Errorf("/dev/%s: %s", name, err)
+		}
+	}
+	links := map[string]string{
+		"fd":     "/proc/self/fd",
+		"stdin":  "/proc/self/fd/0",
+		"stdout": "/proc/self/fd/1",
+		"stderr": "/proc/self/fd/2",
+		"ptmx":   "pts/ptmx",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dev, name)); err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// hide sensitive kernel files behind /dev/null and directories behind empty read-only tmpfs.
+// Done before pivotRoot so we can use /dev/null of the host.
+func maskPaths(newroot string, paths []string) error {
+	for _, p := range paths {
+		target, err := container.SecureJoin(newroot, p)
+		if err != nil {
+			return err
+		}
+		fi, err := os.Stat(target)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			err = syscall.Mount("tmpfs", target, "tmpfs", syscall.MS_RDONLY, "")
+		} else {
+			err = syscall.Mount("/dev/null", target, "", syscall.MS_BIND, "")
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", p, err)
+		}
+	}
+	return nil
+}
+
+// bind mount paths onto themselves and remount them read-only
+func readonlyPaths(newroot string, paths []string) error {
+	for _, p := range paths {
+		target, err := container.SecureJoin(newroot, p)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(target); os.IsNotExist(err) {
+			continue
+		}
+		if err := syscall.Mount(target, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("%s: %s", p, err)
+		}
+		if err := remountReadOnly(target); err != nil {
+			return fmt.Errorf("%s: %s", p, err)
+		}
+	}
+	return nil
+}
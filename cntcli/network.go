@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/odk-/dockerinternals/container"
+	"github.com/odk-/dockerinternals/network"
+)
+
+// network create|ls|inspect|rm
+func networkCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: network create|ls|inspect|rm [name...]")
+	}
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("network create", flag.ExitOnError)
+		driver := fs.String("driver", network.DriverBridge, "bridge, macvlan or ipvlan")
+		parent := fs.String("parent", "", "host interface of macvlan or ipvlan network")
+		mode := fs.String("mode", "", "macvlan mode bridge (default), private or vepa, ipvlan mode l2 (default) or l3")
+		subnet := fs.String("subnet", "", "subnet in CIDR notation, free 192.168.N.0/24 by default")
+		gateway := fs.String("gateway", "", "address of bridge, first one in subnet by default")
+		mtu := fs.Int("mtu", 0, "MTU of bridge and container interfaces, 1500 by default")
+		subnet6 := fs.String("subnet6", "", "IPv6 subnet in CIDR notation, makes network dual-stack. Turns on IPv6 forwarding of host, accept_ra of interfaces becomes 2 to keep SLAAC routes")
+		gateway6 := fs.String("gateway6", "", "IPv6 address of bridge, first one in subnet6 by default")
+		nat6 := fs.Bool("nat6", false, "masquerade outbound IPv6 too, otherwise subnet6 has to be routed to host")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			return errors.New("usage: network create [-driver macvlan|ipvlan -parent if [-mode m]] [-subnet cidr] [-gateway ip] [-mtu n] [-subnet6 cidr [-gateway6 ip] [-nat6]] name")
+		}
+		n, err := network.CreateNetwork(fs.Arg(0), network.Options{
+			Driver:   *driver,
+			Parent:   *parent,
+			Mode:     *mode,
+			Subnet:   *subnet,
+			Gateway:  *gateway,
+			MTU:      *mtu,
+			Subnet6:  *subnet6,
+			Gateway6: *gateway6,
+			NAT6:     *nat6,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println(n.Name)
+	case "ls":
+		networks, err := network.ListNetworks()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tDRIVER\tINTERFACE\tSUBNET\tGATEWAY\tSUBNET6\tMTU")
+		for _, n := range networks {
+			driver, iface, gateway, subnet6, mtu := network.DriverBridge, n.Bridge, n.Gateway, n.Subnet6, strconv.Itoa(n.LinkMTU())
+			if !n.IsBridge() {
+				driver, iface = n.Driver+" "+n.Mode, n.Parent
+			}
+			if gateway == "" {
+				gateway = "-"
+			}
+			if subnet6 == "" {
+				subnet6 = "-"
+			}
+			if mtu == "0" {
+				mtu = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", n.Name, driver, iface, n.Subnet, gateway, subnet6, mtu)
+		}
+		return w.Flush()
+	case "inspect":
+		if len(args) < 2 {
+			return errors.New("usage: network inspect name...")
+		}
+		var result []networkInfo
+		for _, name := range args[1:] {
+			n, err := network.LoadNetwork(name)
+			if err != nil {
+				return err
+			}
+			containers, err := networkContainers(name)
+			if err != nil {
+				return err
+			}
+			leases, err := network.Leases(n)
+			if err != nil {
+				return err
+			}
+			result = append(result, networkInfo{Network: n, Containers: containers, Leases: leases})
+		}
+		j, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(j))
+	case "rm":
+		if len(args) < 2 {
+			return errors.New("usage: network rm name...")
+		}
+		for _, name := range args[1:] {
+			containers, err := networkContainers(name)
+			if err != nil {
+				return err
+			}
+			if len(containers) > 0 {
+				return fmt.Errorf("network %s is used by %v", name, containers)
+			}
+			if err := network.RemoveNetwork(name); err != nil {
+				return err
+			}
+			fmt.Println(name)
+		}
+	default:
+		return fmt.Errorf("unknown network command %q", args[0])
+	}
+	return nil
+}
+
+// output of network inspect
+type networkInfo struct {
+	*network.Network
+	// running containers connected to network
+	Containers []string
+	// addresses of containers, stopped ones keep them until removed
+	Leases map[string]string
+}
+
+// names of running containers connected to network
+func networkContainers(name string) ([]string, error) {
+	states, err := container.ListStates()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, s := range states {
+		if s.Network == name && s.Status != container.StatusExited {
+			names = append(names, s.Name)
+		}
+	}
+	return names, nil
+}
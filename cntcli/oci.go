@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/odk-/dockerinternals/cgroup"
+	"github.com/odk-/dockerinternals/container"
+	"github.com/odk-/dockerinternals/oci"
+	"github.com/odk-/dockerinternals/storage"
+
+	"golang.org/x/sys/unix"
+)
+
+// how long delete waits for killed created container to exit
+const killTimeout = 5 * time.Second
+
+const ociUsage = "usage: oci run|create|start|state|kill|delete <id> [--bundle dir] [signal]"
+
+// oci run|create|start|state|kill|delete <id>, minimal OCI runtime interface
+func ociCommand(args []string) error {
+	if len(args) < 2 {
+		return errors.New(ociUsage)
+	}
+	fs := flag.NewFlagSet("oci "+args[0], flag.ExitOnError)
+	bundle := fs.String("bundle", ".", "bundle directory with config.json")
+	fs.Parse(args[1:])
+	if fs.NArg() == 0 {
+		return errors.New(ociUsage)
+	}
+	// flags are accepted after id too
+	id := fs.Arg(0)
+	fs.Parse(fs.Args()[1:])
+
+	switch args[0] {
+	case "run":
+		return ociRun(id, *bundle, false)
+	case "create":
+		return ociRun(id, *bundle, true)
+	case "start":
+		return ociStart(id)
+	case "state":
+		return ociState(id)
+	case "kill":
+		sig := "SIGTERM"
+		if fs.NArg() > 0 {
+			sig = fs.Arg(0)
+		}
+		return ociKill(id, sig)
+	case "delete":
+		return ociDelete(id)
+	}
+	return fmt.Errorf("unknown oci command %q", args[0])
+}
+
+// create leaves container waiting on exec fifo for start, run goes on and waits for it to exit
+func ociRun(id, bundle string, create bool) error {
+	bundle, err := filepath.Abs(bundle)
+	if err != nil {
+		return err
+	}
+	spec, err := oci.LoadSpec(bundle)
+	if err != nil {
+		return err
+	}
+	config, err := oci.ToConfig(spec, bundle)
+	if err != nil {
+		return err
+	}
+	if _, err := container.LoadState(id); err == nil {
+		return fmt.Errorf("container %s already exists", id)
+	}
+	if config.CloneFlags&syscall.CLONE_NEWUSER != 0 && (config.UIDMappings == nil || config.GIDMappings == nil) {
+		uids, gids, err := container.DefaultIDMappings()
+		if err != nil {
+			return err
+		}
+		if config.UIDMappings == nil {
+			config.UIDMappings = uids
+		}
+		if config.GIDMappings == nil {
+			config.GIDMappings = gids
+		}
+	}
+
+	containerPath := storage.ContainerPath(id)
+	if err := os.MkdirAll(containerPath, 0755); err != nil {
+		return err
+	}
+	if create {
+		config.ExecFifo = filepath.Join(containerPath, "exec.fifo")
+		if err := unix.Mkfifo(config.ExecFifo, 0600); err != nil {
+			return err
+		}
+	}
+
+	if len(config.CreateContainerHooks) > 0 || len(config.StartContainerHooks) > 0 {
+		config.HookState = oci.State(&container.State{Name: id, Bundle: bundle})
+		config.HookState.Status = "creating"
+	}
+
+	cmd := container.InitCommand(config)
+	cg, err := joinCgroup(id, oci.ToResources(spec), cmd)
+	if err != nil {
+		removeOCIFiles(id)
+		return err
+	}
+	if cg != nil {
+		defer cg.Close()
+	}
+	// container is gone for good if we fail before it is created
+	cleanup := func() {
+		cgroup.Remove(id)
+		removeOCIFiles(id)
+	}
+
+	process, err := container.Start(cmd, config)
+	if err != nil {
+		cleanup()
+		return err
+	}
+	state := &container.State{
+		Name:    id,
+		Pid:     cmd.Process.Pid,
+		Status:  container.StatusCreated,
+		Created: time.Now(),
+		Bundle:  bundle,
+	}
+	if spec.Hooks != nil {
+		ociState := oci.State(state)
+		ociState.Status = "creating"
+		if err := oci.RunHooks(append(spec.Hooks.Prestart, spec.Hooks.CreateRuntime...), ociState); err != nil {
+			process.Kill()
+			cleanup()
+			return err
+		}
+	}
+	if err := process.NetworkReady(); err != nil {
+		process.Kill()
+		cleanup()
+		return err
+	}
+
+	if create {
+		if err := process.WaitCreated(); err != nil {
+			cmd.Wait()
+			cleanup()
+			return err
+		}
+		return container.SaveState(state)
+	}
+
+	if err := process.WaitReady(); err != nil {
+		cmd.Wait()
+		cleanup()
+		return err
+	}
+	state.Status = container.StatusRunning
+	if err := container.SaveState(state); err != nil {
+		log.Println("State save failed: ", err)
+	}
+	if spec.Hooks != nil {
+		if err := oci.RunHooks(spec.Hooks.Poststart, oci.State(state)); err != nil {
+			log.Println(err)
+		}
+	}
+	err = cmd.Wait()
+
+	// run removes container when it exits, same as runc
+	state.Status, state.Pid = container.StatusExited, 0
+	if spec.Hooks != nil {
+		if err := oci.RunHooks(spec.Hooks.Poststop, oci.State(state)); err != nil {
+			log.Println(err)
+		}
+	}
+	cleanup()
+	return err
+}
+
+// lets created container exec its command
+func ociStart(id string) error {
+	state, err := container.LoadState(id)
+	if err != nil {
+		return fmt.Errorf("no such container: %s", id)
+	}
+	if state.Status != container.StatusCreated {
+		return fmt.Errorf("container %s is %s, not created", id, state.Status)
+	}
+	fifoPath := filepath.Join(storage.ContainerPath(id), "exec.fifo")
+	// blocks until nsInit opens it for writing, it waits there already
+	fifo, err := os.OpenFile(fifoPath, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer fifo.Close()
+	if _, err := fifo.Read(make([]byte, 1)); err != nil {
+		return fmt.Errorf("container %s didn't start: %s", id, err)
+	}
+	os.Remove(fifoPath)
+
+	state.Status = container.StatusRunning
+	if err := container.SaveState(state); err != nil {
+		return err
+	}
+	spec, err := oci.LoadSpec(state.Bundle)
+	if err != nil {
+		return err
+	}
+	if spec.Hooks != nil {
+		return oci.RunHooks(spec.Hooks.Poststart, oci.State(state))
+	}
+	return nil
+}
+
+// prints state JSON as defined by OCI runtime spec
+func ociState(id string) error {
+	state, err := container.LoadState(id)
+	if err != nil {
+		return fmt.Errorf("no such container: %s", id)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(oci.State(state))
+}
+
+// signal can be a name with or without SIG prefix or a number
+func ociKill(id, sig string) error {
+	state, err := container.LoadState(id)
+	if err != nil {
+		return fmt.Errorf("no such container: %s", id)
+	}
+	if state.Status == container.StatusExited {
+		return fmt.Errorf("container %s is not running", id)
+	}
+	var signal syscall.Signal
+	if n, err := strconv.Atoi(sig); err == nil {
+		signal = syscall.Signal(n)
+	} else {
+		name := strings.ToUpper(sig)
+		if !strings.HasPrefix(name, "SIG") {
+			name = "SIG" + name
+		}
+		if signal = unix.SignalNum(name); signal == 0 {
+			return fmt.Errorf("unknown signal %q", sig)
+		}
+	}
+	return syscall.Kill(state.Pid, signal)
+}
+
+// removes stopped container, created one is killed first. Poststop hook failure doesn't stop
+// removal, it is reported afterwards
+func ociDelete(id string) error {
+	state, err := container.LoadState(id)
+	if err != nil {
+		return fmt.Errorf("no such container: %s", id)
+	}
+	switch state.Status {
+	case container.StatusExited:
+	case container.StatusCreated:
+		syscall.Kill(state.Pid, syscall.SIGKILL)
+		// cgroup can't be removed while process is still in it
+		if err := container.WaitExited(state.Pid, killTimeout); err != nil {
+			return err
+		}
+		state.Status, state.Pid = container.StatusExited, 0
+	default:
+		return fmt.Errorf("container %s is %s, kill it first", id, state.Status)
+	}
+	spec, hookErr := oci.LoadSpec(state.Bundle)
+	if hookErr == nil && spec.Hooks != nil {
+		hookErr = oci.RunHooks(spec.Hooks.Poststop, oci.State(state))
+	}
+	if err := cgroup.Remove(id); err != nil {
+		log.Println("cgroup removal failed: ", err)
+	}
+	if err := removeOCIFiles(id); err != nil {
+		return err
+	}
+	return hookErr
+}
+
+// removes only files oci commands create. Directory of container can be shared with bundle
+// that has rootfs there (id same as bundle -n), it is removed only when left empty
+func removeOCIFiles(id string) error {
+	containerPath := storage.ContainerPath(id)
+	for _, f := range []string{"state.json", "exec.fifo"} {
+		if err := os.Remove(filepath.Join(containerPath, f)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	os.Remove(containerPath)
+	return nil
+}
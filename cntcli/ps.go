@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/odk-/dockerinternals/cgroup"
+	"github.com/odk-/dockerinternals/container"
+	"github.com/odk-/dockerinternals/network"
+	"github.com/odk-/dockerinternals/storage"
+)
+
+// ps [-a]
+func psCommand(args []string) error {
+	fs := flag.NewFlagSet("ps", flag.ExitOnError)
+	all := fs.Bool("a", false, "show exited containers too")
+	fs.Parse(args)
+
+	states, err := container.ListStates()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tIMAGE\tSTATUS\tPID\tCREATED")
+	for _, s := range states {
+		if s.Status == container.StatusExited && !*all {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", s.Name, s.Image, s.Status, s.Pid, s.Created.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+// pause name...
+func pauseCommand(args []string) error {
+	return setPaused(args, true)
+}
+
+// unpause name...
+func unpauseCommand(args []string) error {
+	return setPaused(args, false)
+}
+
+func setPaused(names []string, paused bool) error {
+	if len(names) == 0 {
+		return fmt.Errorf("at least one container name required")
+	}
+	for _, name := range names {
+		state, err := container.LoadState(name)
+		if err != nil {
+			return fmt.Errorf("no such container: %s", name)
+		}
+		if paused {
+			if state.Status != container.StatusRunning {
+				return fmt.Errorf("container %s is %s", name, state.Status)
+			}
+			if err := cgroup.Freeze(name); err != nil {
+				return err
+			}
+			state.Status = container.StatusPaused
+		} else {
+			if state.Status != container.StatusPaused {
+				return fmt.Errorf("container %s is not paused", name)
+			}
+			if err := cgroup.Thaw(name); err != nil {
+				return err
+			}
+			state.Status = container.StatusRunning
+		}
+		if err := container.SaveState(state); err != nil {
+			return err
+		}
+		fmt.Println(name)
+	}
+	return nil
+}
+
+// rm name..., removes stopped containers with their files and network addresses
+func rmCommand(names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("at least one container name required")
+	}
+	for _, name := range names {
+		state, err := container.LoadState(name)
+		if err != nil {
+			return fmt.Errorf("no such container: %s", name)
+		}
+		if state.Status != container.StatusExited {
+			return fmt.Errorf("container %s is %s, stop it first", name, state.Status)
+		}
+		if state.Network != "" {
+			if n, err := network.LoadNetwork(state.Network); err == nil {
+				if err := network.Release(n, name); err != nil {
+					return err
+				}
+			}
+			if err := network.Teardown(name); err != nil {
+				return err
+			}
+			// rules stay behind when cntcli was killed
+			if err := network.UnpublishPorts(name); err != nil {
+				return err
+			}
+		}
+		if err := cgroup.Remove(name); err != nil {
+			return err
+		}
+		// rootfs is normally unmounted on exit, not when cntcli was killed
+		containerPath := storage.ContainerPath(name)
+		syscall.Unmount(filepath.Join(containerPath, "rootfs"), syscall.MNT_DETACH)
+		if err := os.RemoveAll(containerPath); err != nil {
+			return err
+		}
+		fmt.Println(name)
+	}
+	return nil
+}
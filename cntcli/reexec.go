@@ -1,60 +1,346 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
 
+	"github.com/docker/docker/pkg/reexec"
+	"github.com/odk-/dockerinternals/capabilities"
+	"github.com/odk-/dockerinternals/container"
 	"github.com/odk-/dockerinternals/network"
+	"github.com/odk-/dockerinternals/oci"
+	"github.com/odk-/dockerinternals/seccomp"
+	"github.com/odk-/dockerinternals/storage"
+
+	"golang.org/x/sys/unix"
 )
 
+// used when neither image nor user sets PATH, same as docker
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// argument of nsInit executed again after id mappings were written
+const mappedArg = "mapped"
+
+// sync socket to parent, setup errors are reported through it
+var parent *container.InitSync
+
+// reports setup failure to parent and exits
+func fail(stage string, err error) {
+	if parent == nil || parent.Fail(stage, err) != nil {
+		fmt.Printf("Error %s - %s\n", stage, err)
+	}
+	os.Exit(1)
+}
+
 // running inside namespace before our command
 func nsInit() {
-	newrootPath := os.Args[1]
-	comm := os.Args[2]
+	// we are nobody until parent writes id mappings, after that exec again
+	// to get capabilities in our user namespace
+	if len(os.Args) < 2 || os.Args[1] != mappedArg {
+		if err := container.WaitForMappings(); err != nil {
+			fmt.Printf("Error %s\n", err)
+			os.Exit(1)
+		}
+		if ok, err := capabilities.HasEffective("CAP_SYS_ADMIN"); err == nil && !ok {
+			err = syscall.Exec(reexec.Self(), []string{os.Args[0], mappedArg}, os.Environ())
+			fmt.Printf("Error executing nsInit again - %s\n", err)
+			os.Exit(1)
+		}
+	}
 
-	if err := mountProc(newrootPath); err != nil {
-		fmt.Printf("Error mounting /proc - %s\n", err)
+	var (
+		config *container.Config
+		err    error
+	)
+	if parent, config, err = container.OpenInitSync(); err != nil {
+		fmt.Printf("Error receiving container config - %s\n", err)
 		os.Exit(1)
 	}
+	newrootPath := config.Rootfs
+
+	// hooks get our pid as host sees it, /proc of host is still mounted here
+	if config.HookState != nil {
+		self, err := os.Readlink("/proc/self")
+		if err == nil {
+			config.HookState.Pid, err = strconv.Atoi(self)
+		}
+		if err != nil {
+			fail("reading pid", err)
+		}
+	}
+
+	// parent runs hooks and moves interfaces into our namespace meanwhile
+	if err := parent.WaitNetwork(); err != nil {
+		fail("waiting for network", err)
+	}
+
+	// fifo is on host, it has to be opened before pivot_root
+	execFifo := -1
+	if config.ExecFifo != "" {
+		if execFifo, err = unix.Open(config.ExecFifo, unix.O_PATH|unix.O_CLOEXEC, 0); err != nil {
+			fail("opening exec fifo", err)
+		}
+	}
+
+	if err := makeRootPrivate(); err != nil {
+		fail("setting mount propagation", err)
+	}
+
+	if config.Rootless {
+		if err := storage.MountRootFS(filepath.Dir(newrootPath)); err != nil {
+			fail("mounting rootfs", err)
+		}
+		if err := container.PopulateVolumes(config.Mounts, newrootPath); err != nil {
+			fail("populating volumes", err)
+		}
+	}
+
+	if err := mountProc(newrootPath); err != nil {
+		fail("mounting /proc", err)
+	}
+
+	if err := mountFs(newrootPath, config.FsMounts); err != nil {
+		fail("mounting filesystems", err)
+	}
+
+	if err := maskPaths(newrootPath, config.MaskedPaths); err != nil {
+		fail("masking paths", err)
+	}
+
+	if err := readonlyPaths(newrootPath, config.ReadonlyPaths); err != nil {
+		fail("making paths read-only", err)
+	}
+
+	if err := mountEtcFiles(newrootPath, config.EtcDir); err != nil {
+		fail("mounting /etc files", err)
+	}
+
+	if err := mountVolumes(newrootPath, config.Mounts); err != nil {
+		fail("mounting volumes", err)
+	}
+
+	if err := mountTmpfs(newrootPath, config.Tmpfs); err != nil {
+		fail("mounting tmpfs", err)
+	}
+
+	if len(config.CreateContainerHooks) > 0 {
+		if err := oci.RunHooks(config.CreateContainerHooks, config.HookState); err != nil {
+			fail("running createContainer hooks", err)
+		}
+	}
 
 	if err := pivotRoot(newrootPath); err != nil {
-		fmt.Printf("Error running pivot_root - %s\n", err)
-		os.Exit(1)
+		fail("running pivot_root", err)
 	}
 
-	if err := syscall.Sethostname([]byte("container")); err != nil {
-		fmt.Printf("Error setting hostname - %s\n", err)
-		os.Exit(1)
+	// only rootfs itself, volumes, tmpfs and /proc are separate mounts and keep their mode
+	if config.ReadOnly {
+		if err := remountReadOnly("/"); err != nil {
+			fail("remounting rootfs read-only", err)
+		}
 	}
 
-	if err := network.FinalConfig(); err != nil {
-		fmt.Printf("Error waiting for network - %s\n", err)
-		os.Exit(1)
+	// without own UTS namespace this would rename the host
+	if config.CloneFlags&syscall.CLONE_NEWUTS != 0 && config.Hostname != "" {
+		if err := syscall.Sethostname([]byte(config.Hostname)); err != nil {
+			fail("setting hostname", err)
+		}
 	}
 
-	nsRun(comm)
+	// even container without network expects working 127.0.0.1
+	if config.CloneFlags&syscall.CLONE_NEWNET != 0 {
+		if err := network.LoopbackUp(); err != nil {
+			fail("bringing up loopback", err)
+		}
+	}
+
+	// other network modes are configured from outside or have nothing to configure
+	if config.Network == container.NetworkBridge {
+		addresses, gateways := []string{config.IPAddress}, []string{config.Gateway}
+		if config.IPAddress6 != "" {
+			addresses = append(addresses, config.IPAddress6)
+			gateways = append(gateways, config.Gateway6)
+		}
+		if err := network.FinalConfig(config.NetworkInterface, addresses, gateways, config.MTU); err != nil {
+			fail("configuring network", err)
+		}
+	}
+
+	nsRun(config, execFifo)
 }
 
 // actual execution of our command
-// here we could inject stuff like env args
-func nsRun(comm string) {
-	cmd := exec.Command(comm)
+func nsRun(config *container.Config, execFifo int) {
+	if len(config.Args) == 0 {
+		fail("preparing command", errors.New("no command given"))
+	}
+	comm := config.Args[0]
+
+	// passwd and group of the image are visible now, we are after pivot_root
+	user, err := container.LookupUser(config.User, "/etc/passwd", "/etc/group")
+	if err != nil {
+		fail("resolving user", err)
+	}
+	for _, gid := range config.AdditionalGids {
+		if !containsGid(user.Groups, gid) {
+			user.Groups = append(user.Groups, gid)
+		}
+	}
+	if err := user.CheckMapped(); err != nil {
+		fail("switching user", err)
+	}
+
+	// defaults are overridden by image and user environment
+	env := container.MergeEnv([]string{
+		"PATH=" + defaultPath,
+		"HOSTNAME=" + config.Hostname,
+		"HOME=" + user.Home,
+		"PS1=-[container]- # ",
+	}, config.Env)
+	// command is looked up in PATH of container, not the one we got from host
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "PATH=") {
+			os.Setenv("PATH", kv[len("PATH="):])
+		}
+	}
+
+	cmd := exec.Command(comm, config.Args[1:]...)
+	cmd.Env = env
+	cmd.Dir = config.Cwd
 
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	cmd.Env = []string{"PS1=-[container]- # "}
+	// credentials are switched in forked child right before exec. It needs CAP_SETUID and CAP_SETGID
+	// so plain root is left alone, --cap-drop of those works then
+	if user.UID != 0 || user.GID != 0 || len(user.Groups) > 1 {
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Credential: &syscall.Credential{
+				Uid:         user.UID,
+				Gid:         user.GID,
+				Groups:      user.Groups,
+				NoSetGroups: !setgroupsAllowed(),
+			},
+		}
+	}
+
+	// limits are inherited by command, raising hard ones needs CAP_SYS_RESOURCE we may drop below
+	for _, r := range config.Rlimits {
+		if err := unix.Setrlimit(r.Type, &unix.Rlimit{Cur: r.Soft, Max: r.Hard}); err != nil {
+			fail("setting rlimits", fmt.Errorf("%d: %s", r.Type, err))
+		}
+	}
+
+	// capabilities are per thread, command must be forked from the one we set them on
+	runtime.LockOSThread()
+
+	if config.NoNewPrivileges {
+		if err := capabilities.SetNoNewPrivs(); err != nil {
+			fail("setting no_new_privs", err)
+		}
+	}
+
+	// from now on nsInit itself is filtered too. Installing filter needs CAP_SYS_ADMIN
+	// when no_new_privs is not set so it goes before capabilities are dropped
+	if config.Seccomp != nil {
+		if err := seccomp.Install(config.Seccomp, config.Capabilities); err != nil {
+			fail("installing seccomp filter", err)
+		}
+	}
+
+	switch {
+	case config.KeepCapabilities:
+	case config.CapabilitySets != nil:
+		if err := capabilities.ApplySets(*config.CapabilitySets); err != nil {
+			fail("setting capabilities", err)
+		}
+	default:
+		if err := capabilities.Apply(config.Capabilities); err != nil {
+			fail("setting capabilities", err)
+		}
+	}
+
+	// we are init of pid namespace, signals without handler are ignored by kernel. They are
+	// caught from now on and forwarded to command once it runs, bursts fit into buffer
+	signals := make(chan os.Signal, 32)
+	signal.Notify(signals)
+
+	if execFifo >= 0 {
+		if err := parent.Created(); err != nil {
+			fail("reporting created state", err)
+		}
+		if err := waitExecFifo(execFifo); err != nil {
+			fail("waiting on exec fifo", err)
+		}
+	}
+
+	if len(config.StartContainerHooks) > 0 {
+		if err := oci.RunHooks(config.StartContainerHooks, config.HookState); err != nil {
+			fail("running startContainer hooks", err)
+		}
+	}
+
+	if err := cmd.Start(); err != nil {
+		fail("starting "+comm, err)
+	}
+	parent.Ready()
 
-	if err := cmd.Run(); err != nil {
+	go func() {
+		for sig := range signals {
+			// SIGPIPE is ours, from writing to sync socket "oci create" closed already
+			if sig != syscall.SIGCHLD && sig != syscall.SIGURG && sig != syscall.SIGPIPE {
+				cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
 		fmt.Printf("Error running the %s command - %s\n", comm, err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
 
+// exit status of command is passed through nsInit and cntcli, killed one gives 128+signal like shells do
+func exitCode(err error) int {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return 128 + int(status.Signal())
+			}
+			return status.ExitStatus()
+		}
+	}
+	return 1
+}
+
+func containsGid(gids []uint32, gid uint32) bool {
+	for _, g := range gids {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
+
+// setgroups is denied in user namespace when gid map was written by unprivileged user
+func setgroupsAllowed() bool {
+	content, err := ioutil.ReadFile("/proc/self/setgroups")
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(content)) == "allow"
+}
+
 // trick to mount unpacked container as / in ns
 func pivotRoot(newroot string) error {
 	putold := filepath.Join(newroot, "/.pivot_root")
@@ -106,12 +392,14 @@ func pivotRoot(newroot string) error {
 //empty ns doesn't have /proc
 func mountProc(newroot string) error {
 	source := "proc"
-	target := filepath.Join(newroot, "/proc")
 	fstype := "proc"
 	flags := 0
 	data := ""
 
-	os.MkdirAll(target, 0755)
+	target, err := mountTarget(newroot, "/proc", true)
+	if err != nil {
+		return err
+	}
 	if err := syscall.Mount(
 		source,
 		target,
@@ -124,3 +412,50 @@ func mountProc(newroot string) error {
 
 	return nil
 }
+
+// bind mount hostname, hosts and resolv.conf generated by parent over the ones from image.
+// Without etcDir (OCI bundles) rootfs files are left alone
+func mountEtcFiles(newroot, etcDir string) error {
+	if etcDir == "" {
+		return nil
+	}
+	// image /etc can be a symlink too, it must not take us out of rootfs
+	etc, err := mountTarget(newroot, "/etc", true)
+	if err != nil {
+		return err
+	}
+	for _, name := range container.EtcFiles {
+		source := filepath.Join(etcDir, name)
+		target := filepath.Join(etc, name)
+
+		// target can be a symlink (resolv.conf often is), replace it so we don't follow it outside rootfs
+		if fi, err := os.Lstat(target); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		f.Close()
+
+		if err := syscall.Mount(source, target, "", syscall.MS_BIND, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blocks until "oci start" opens the fifo for reading. Opening through /proc reopens
+// file we got before pivot_root, this time for writing
+func waitExecFifo(fd int) error {
+	fifo, err := os.OpenFile(fmt.Sprintf("/proc/self/fd/%d", fd), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer fifo.Close()
+	unix.Close(fd)
+	_, err = fifo.Write([]byte{0})
+	return err
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/odk-/dockerinternals/capabilities"
+	"github.com/odk-/dockerinternals/cgroup"
+	"github.com/odk-/dockerinternals/container"
+	"github.com/odk-/dockerinternals/network"
+	"github.com/odk-/dockerinternals/seccomp"
+)
+
+// runs container in foreground and cleans up after it exits
+func runContainer() error {
+	args, err := container.SplitCommand(*command)
+	if err != nil {
+		return err
+	}
+	config := &container.Config{
+		Args:            args,
+		Env:             envVars,
+		Cwd:             *workdir,
+		Hostname:        *hostname,
+		DNS:             dnsServers,
+		ExtraHosts:      extraHosts,
+		ReadOnly:        *readOnly,
+		User:            *user,
+		Seccomp:         seccomp.DefaultProfile(),
+		NoNewPrivileges: true,
+		MaskedPaths:     container.DefaultMaskedPaths,
+		ReadonlyPaths:   container.DefaultReadonlyPaths,
+	}
+	if err := parseNetworkMode(config); err != nil {
+		return err
+	}
+	if os.Geteuid() != 0 {
+		config.Rootless = true
+		// bridge and veth need CAP_NET_ADMIN on host, without root userspace network is the best we can do
+		if config.Network == container.NetworkBridge {
+			config.Network = container.NetworkNone
+			if network.SlirpAvailable() {
+				config.Network = container.NetworkSlirp
+			} else {
+				log.Println("slirp4netns not found, rootless container has no network")
+			}
+		}
+	}
+	var ports []network.PortMapping
+	for _, spec := range publish {
+		m, err := network.ParsePortMapping(spec)
+		if err != nil {
+			return err
+		}
+		ports = append(ports, m)
+	}
+	var bridgeNet *network.Network
+	if config.Network == container.NetworkBridge {
+		var err error
+		_, config.NetworkInterface = network.VethNames(*containerName)
+		if bridgeNet, err = network.LoadNetwork(*networkName); err != nil {
+			return err
+		}
+		// macvlan and ipvlan containers have LAN address, there is nothing to publish
+		if len(ports) > 0 && !bridgeNet.IsBridge() {
+			return fmt.Errorf("ports can't be published on %s network", bridgeNet.Driver)
+		}
+		if *ip6Address != "" && bridgeNet.Subnet6 == "" {
+			return fmt.Errorf("network %s has no IPv6 subnet", bridgeNet.Name)
+		}
+		config.Gateway, config.Gateway6, config.MTU = bridgeNet.Gateway, bridgeNet.Gateway6, bridgeNet.LinkMTU()
+	}
+	if len(ports) > 0 && config.Network != container.NetworkBridge {
+		return errors.New("ports can be published only for containers on bridge network")
+	}
+	if (*ipAddress != "" || *ip6Address != "") && config.Network != container.NetworkBridge {
+		return errors.New("address can be set only for containers on bridge network")
+	}
+	if err := parseSecurityOpts(config); err != nil {
+		return err
+	}
+	caps, err := capabilities.Merge(capabilities.DefaultCaps, capAdd, capDrop)
+	if err != nil {
+		return err
+	}
+	config.Capabilities = caps
+	for _, v := range volumes {
+		m, err := container.ParseMount(v)
+		if err != nil {
+			return err
+		}
+		config.Mounts = append(config.Mounts, m)
+	}
+	for _, spec := range tmpfs {
+		t, err := container.ParseTmpfs(spec)
+		if err != nil {
+			return err
+		}
+		config.Tmpfs = append(config.Tmpfs, t)
+	}
+	for _, spec := range uidMaps {
+		m, err := container.ParseIDMap(spec)
+		if err != nil {
+			return err
+		}
+		config.UIDMappings = append(config.UIDMappings, m)
+	}
+	for _, spec := range gidMaps {
+		m, err := container.ParseIDMap(spec)
+		if err != nil {
+			return err
+		}
+		config.GIDMappings = append(config.GIDMappings, m)
+	}
+	resources, err := parseResources()
+	if err != nil {
+		return err
+	}
+
+	cmd, newRoot, err := container.SetNameSpaces(*imageName, *containerName, config)
+	if err != nil {
+		return err
+	}
+
+	// do the clean unmount on exit, rootless rootfs lives only in container mount namespace
+	if !config.Rootless {
+		defer unmount(newRoot)
+	}
+
+	cg, err := joinCgroup(*containerName, resources, cmd)
+	if err != nil {
+		return err
+	}
+	if cg != nil {
+		defer cgroup.Remove(*containerName)
+		defer cg.Close()
+	}
+
+	// addresses are leased last, until state is saved rm can't release them
+	var leased []string
+	if bridgeNet != nil {
+		if leased, err = leaseAddresses(bridgeNet, config); err != nil {
+			return err
+		}
+	}
+	process, err := container.Start(cmd, config)
+	if err != nil {
+		releaseAddresses(bridgeNet, leased)
+		log.Printf("Error starting the reexec.Command - %s\n", err)
+		return err
+	}
+
+	log.Println("pid: ", cmd.Process.Pid)
+	state := &container.State{
+		Name:    *containerName,
+		Image:   *imageName,
+		Pid:     cmd.Process.Pid,
+		Status:  container.StatusRunning,
+		Created: time.Now(),
+	}
+	if bridgeNet != nil {
+		state.Network = bridgeNet.Name
+		state.Ports = ports
+	}
+	if err := container.SaveState(state); err != nil {
+		log.Println("State save failed: ", err)
+	}
+	defer func() {
+		state.Status, state.Pid = container.StatusExited, 0
+		container.SaveState(state)
+	}()
+
+	switch config.Network {
+	case container.NetworkBridge:
+		if err = network.Setup(bridgeNet, *containerName, cmd.Process.Pid); err == nil {
+			defer network.Teardown(*containerName)
+			err = publishPorts(*containerName, config.IPAddress, ports)
+			defer network.UnpublishPorts(*containerName)
+		}
+	case container.NetworkSlirp:
+		var slirp *os.Process
+		if slirp, err = network.StartSlirp(cmd.Process.Pid); err == nil {
+			defer slirp.Kill()
+		}
+	}
+	if err != nil {
+		process.Kill()
+		return err
+	}
+	if err := process.NetworkReady(); err != nil {
+		process.Kill()
+		return err
+	}
+	// child reports its setup failures here, it exits on its own after that
+	if err := process.WaitReady(); err != nil {
+		cmd.Wait()
+		return err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		log.Printf("Error waiting for the reexec.Command - %s\n", err)
+		return err
+	}
+	return nil
+}
+
+// lease is kept after exit so restarted container gets the same address, rm releases it.
+// Returns addresses leased by this call, those held from previous run are not among them
+func leaseAddresses(n *network.Network, config *container.Config) ([]string, error) {
+	held, err := network.Leases(n)
+	if err != nil {
+		return nil, err
+	}
+	var leased []string
+	keep := func(address string) {
+		if ip, _, err := net.ParseCIDR(address); err == nil && held[ip.String()] != *containerName {
+			leased = append(leased, address)
+		}
+	}
+	if config.IPAddress, err = network.Allocate(n, *containerName, *ipAddress); err != nil {
+		return nil, err
+	}
+	keep(config.IPAddress)
+	if n.Subnet6 != "" {
+		if config.IPAddress6, err = network.Allocate6(n, *containerName, *ip6Address); err != nil {
+			releaseAddresses(n, leased)
+			return nil, err
+		}
+		keep(config.IPAddress6)
+	}
+	return leased, nil
+}
+
+func releaseAddresses(n *network.Network, addresses []string) {
+	for _, a := range addresses {
+		if err := network.ReleaseAddress(n, *containerName, a); err != nil {
+			log.Println("Address release failed: ", err)
+		}
+	}
+}
+
+// starts localhost proxies and adds DNAT rules, proxies are stopped when cntcli exits
+func publishPorts(name, ip string, ports []network.PortMapping) error {
+	// proxy fails on taken port, so it goes first
+	for _, m := range ports {
+		if _, err := network.StartProxy(m, ip); err != nil {
+			return err
+		}
+	}
+	return network.PublishPorts(name, ip, ports)
+}
+
+// --network is name of bridge network unless it is none, host or container:<name>
+func parseNetworkMode(config *container.Config) error {
+	config.Network = container.NetworkBridge
+	switch {
+	case *networkName == container.NetworkNone:
+		config.Network = container.NetworkNone
+	case *networkName == container.NetworkHost:
+		config.Network = container.NetworkHost
+		config.CloneFlags = container.DefaultCloneFlags &^ syscall.CLONE_NEWNET
+	case strings.HasPrefix(*networkName, container.NetworkContainer+":"):
+		target := strings.TrimPrefix(*networkName, container.NetworkContainer+":")
+		// namespace of other container belongs to its user namespace, only root can get in
+		if os.Geteuid() != 0 {
+			return errors.New("joining network of another container needs root")
+		}
+		state, err := container.LoadState(target)
+		if err != nil {
+			return fmt.Errorf("no such container: %s", target)
+		}
+		if state.Status == container.StatusExited {
+			return fmt.Errorf("container %s is not running", target)
+		}
+		config.Network = container.NetworkContainer
+		config.NetNsPath = fmt.Sprintf("/proc/%d/ns/net", state.Pid)
+		config.CloneFlags = container.DefaultCloneFlags &^ syscall.CLONE_NEWNET
+	}
+	return nil
+}
+
+// creates cgroup of container and makes cmd start in it, so limits apply before anything runs there.
+// Without limits missing cgroup support is not fatal, nil is returned then.
+// Returned directory has to be kept open until cmd is started
+func joinCgroup(name string, resources *cgroup.Resources, cmd *exec.Cmd) (*os.File, error) {
+	if err := cgroup.Create(name, resources); err != nil {
+		if *resources != (cgroup.Resources{}) {
+			return nil, err
+		}
+		log.Println("Running without cgroup: ", err)
+		return nil, nil
+	}
+	cg, err := cgroup.Open(name)
+	if err != nil {
+		cgroup.Remove(name)
+		return nil, err
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(cg.Fd())
+	return cg, nil
+}
+
+// converts resource flags into cgroup limits
+func parseResources() (*cgroup.Resources, error) {
+	res := &cgroup.Resources{
+		CPUs:      *cpus,
+		CPUShares: *cpuShares,
+		PidsLimit: *pidsLimit,
+		IOWeight:  *ioWeight,
+	}
+	var err error
+	if *memory != "" {
+		if res.Memory, err = cgroup.ParseSize(*memory); err != nil {
+			return nil, err
+		}
+	}
+	if *memorySwap == "-1" {
+		res.MemorySwap = -1
+	} else if *memorySwap != "" {
+		if res.MemorySwap, err = cgroup.ParseSize(*memorySwap); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// applies --security-opt values on top of defaults
+func parseSecurityOpts(config *container.Config) error {
+	for _, opt := range securityOpts {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			// docker accepts plain no-new-privileges as well
+			if opt != "no-new-privileges" {
+				return fmt.Errorf("invalid --security-opt %q, expecting key=value", opt)
+			}
+			kv = append(kv, "true")
+		}
+		switch kv[0] {
+		case "no-new-privileges":
+			value, err := strconv.ParseBool(kv[1])
+			if err != nil {
+				return fmt.Errorf("invalid no-new-privileges value %q", kv[1])
+			}
+			config.NoNewPrivileges = value
+		case "seccomp":
+			if kv[1] == "unconfined" {
+				config.Seccomp = nil
+				continue
+			}
+			profile, err := seccomp.LoadProfile(kv[1])
+			if err != nil {
+				return fmt.Errorf("loading seccomp profile: %s", err)
+			}
+			config.Seccomp = profile
+		case "systempaths":
+			// for debugging only, gives container full view of /proc
+			if kv[1] != "unconfined" {
+				return fmt.Errorf("invalid systempaths value %q, only unconfined is supported", kv[1])
+			}
+			config.MaskedPaths = nil
+			config.ReadonlyPaths = nil
+		default:
+			return fmt.Errorf("unknown --security-opt %q", kv[0])
+		}
+	}
+	return nil
+}
+
+func unmount(path string) error {
+	return syscall.Unmount(path, 0)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/odk-/dockerinternals/cgroup"
+	"github.com/odk-/dockerinternals/container"
+	"github.com/odk-/dockerinternals/network"
+)
+
+// single sample of container resource usage
+type statsRecord struct {
+	Name     string
+	Read     time.Time
+	Cgroup   *cgroup.Stats
+	Networks map[string]network.InterfaceStats
+}
+
+// stats [--json] [--no-stream] [name...]
+func statsCommand(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print one JSON record per container and exit")
+	noStream := fs.Bool("no-stream", false, "print table once and exit")
+	fs.Parse(args)
+
+	names := fs.Args()
+	if len(names) == 0 {
+		states, err := container.ListStates()
+		if err != nil {
+			return err
+		}
+		for _, s := range states {
+			if s.Status != container.StatusExited {
+				names = append(names, s.Name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return errors.New("no running containers")
+	}
+
+	if *asJSON {
+		records, err := readStats(names)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// CPU usage is a counter, percentage needs two samples
+	prev, err := readStats(names)
+	if err != nil {
+		return err
+	}
+	for {
+		time.Sleep(time.Second)
+		cur, err := readStats(names)
+		if err != nil {
+			return err
+		}
+		if !*noStream {
+			// clear screen and move cursor home
+			fmt.Print("\033[2J\033[H")
+		}
+		printStatsTable(prev, cur)
+		if *noStream {
+			return nil
+		}
+		prev = cur
+	}
+}
+
+func readStats(names []string) ([]*statsRecord, error) {
+	var records []*statsRecord
+	for _, name := range names {
+		state, err := container.LoadState(name)
+		if err != nil {
+			return nil, fmt.Errorf("no such container: %s", name)
+		}
+		if state.Status == container.StatusExited {
+			return nil, fmt.Errorf("container %s is not running", name)
+		}
+		r := &statsRecord{Name: name, Read: time.Now()}
+		if r.Cgroup, err = cgroup.GetStats(name); err != nil {
+			return nil, err
+		}
+		if r.Networks, err = network.GetStats(state.Pid); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+func printStatsTable(prev, cur []*statsRecord) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCPU %\tMEM USAGE / LIMIT\tMEM %\tNET I/O\tBLOCK I/O\tPIDS")
+	for i, r := range cur {
+		var cpu float64
+		if elapsed := r.Read.Sub(prev[i].Read).Microseconds(); elapsed > 0 {
+			used := r.Cgroup.CPUStat["usage_usec"] - prev[i].Cgroup.CPUStat["usage_usec"]
+			cpu = float64(used) / float64(elapsed) * 100
+		}
+
+		limit, memPercent := "max", "-"
+		if r.Cgroup.MemoryLimit > 0 {
+			limit = humanSize(r.Cgroup.MemoryLimit)
+			memPercent = fmt.Sprintf("%.2f%%", float64(r.Cgroup.MemoryCurrent)/float64(r.Cgroup.MemoryLimit)*100)
+		}
+
+		var rx, tx, read, written uint64
+		for _, n := range r.Networks {
+			rx += n.RxBytes
+			tx += n.TxBytes
+		}
+		for _, dev := range r.Cgroup.IOStat {
+			read += dev["rbytes"]
+			written += dev["wbytes"]
+		}
+
+		fmt.Fprintf(w, "%s\t%.2f%%\t%s / %s\t%s\t%s / %s\t%s / %s\t%d\n",
+			r.Name, cpu,
+			humanSize(r.Cgroup.MemoryCurrent), limit, memPercent,
+			humanSize(rx), humanSize(tx),
+			humanSize(read), humanSize(written),
+			r.Cgroup.PidsCurrent)
+	}
+	w.Flush()
+}
+
+func humanSize(bytes uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	size := float64(bytes)
+	i := 0
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%s", size, units[i])
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/odk-/dockerinternals/storage"
+)
+
+// volume create|ls|rm
+func volumeCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: volume create|ls|rm [name...]")
+	}
+	switch args[0] {
+	case "create":
+		if len(args) < 2 {
+			return errors.New("usage: volume create name...")
+		}
+		for _, name := range args[1:] {
+			if err := storage.CreateVolume(name); err != nil {
+				return err
+			}
+			fmt.Println(name)
+		}
+	case "ls":
+		names, err := storage.ListVolumes()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	case "rm":
+		if len(args) < 2 {
+			return errors.New("usage: volume rm name...")
+		}
+		for _, name := range args[1:] {
+			if err := storage.RemoveVolume(name); err != nil {
+				return err
+			}
+			fmt.Println(name)
+		}
+	default:
+		return fmt.Errorf("unknown volume command %q", args[0])
+	}
+	return nil
+}
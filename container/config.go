@@ -0,0 +1,207 @@
+package container
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/odk-/dockerinternals/capabilities"
+	"github.com/odk-/dockerinternals/seccomp"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// DefaultCloneFlags are namespaces every container started from image gets
+const DefaultCloneFlags = syscall.CLONE_NEWNS |
+	syscall.CLONE_NEWUTS |
+	syscall.CLONE_NEWIPC |
+	syscall.CLONE_NEWPID |
+	syscall.CLONE_NEWNET |
+	syscall.CLONE_NEWUSER
+
+// DefaultMaskedPaths are hidden from container, same list as docker uses
+var DefaultMaskedPaths = []string{
+	"/proc/asound",
+	"/proc/acpi",
+	"/proc/interrupts",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/sys/firmware",
+	"/sys/devices/virtual/powercap",
+}
+
+// DefaultReadonlyPaths are visible to container but can't be written, same list as docker uses
+var DefaultReadonlyPaths = []string{
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+	"/proc/sysrq-trigger",
+}
+
+// network modes of container
+const (
+	// connected to network from network create: veth on its bridge or macvlan/ipvlan link on its parent, needs root
+	NetworkBridge = "bridge"
+	// network namespace of the host is shared
+	NetworkHost = "host"
+	// only loopback in own network namespace
+	NetworkNone = "none"
+	// userspace network through slirp4netns, usable by rootless containers
+	NetworkSlirp = "slirp4netns"
+	// network namespace of another container is joined, see NetNsPath
+	NetworkContainer = "container"
+)
+
+// Config is full specification of a container. Parent sends it to nsInit over sync socket
+// and nsInit applies it, see sync.go
+type Config struct {
+	// path to mounted (or for rootless to be mounted) rootfs, filled in by SetNameSpaces
+	Rootfs string
+	// namespaces created for container as CLONE_NEW* flags, user namespace needs id mappings
+	CloneFlags uintptr
+	// directory with generated EtcFiles, empty keeps ones from rootfs
+	EtcDir string
+	// when set nsInit stops before starting command until somebody opens this fifo for reading
+	ExecFifo string `json:",omitempty"`
+	// command with arguments, Env in form KEY=value, Cwd defaults to /
+	Args       []string
+	Env        []string
+	Cwd        string
+	Hostname   string
+	DNS        []string
+	ExtraHosts []string
+	Mounts     []Mount
+	Tmpfs      []Tmpfs
+	// any other filesystems like sysfs or devpts, mounted before Mounts and Tmpfs
+	FsMounts []FsMount `json:",omitempty"`
+	// rootfs is remounted read-only after pivot_root
+	ReadOnly bool
+	// user[:group] resolved inside container, empty means root
+	User string
+	// OCI bundles only, supplementary groups added to the ones of User
+	AdditionalGids []uint32 `json:",omitempty"`
+	// OCI bundles only, resource limits of command
+	Rlimits []Rlimit `json:",omitempty"`
+	// OCI bundles only, nsInit runs createContainer hooks after mounts before pivot_root and
+	// startContainer ones right before command. HookState goes to their stdin, nsInit fills in its pid
+	CreateContainerHooks []specs.Hook `json:",omitempty"`
+	StartContainerHooks  []specs.Hook `json:",omitempty"`
+	HookState            *specs.State `json:",omitempty"`
+	// nil means unconfined
+	Seccomp *seccomp.Profile
+	// capabilities left to container process, names like CAP_CHOWN
+	Capabilities []string
+	// OCI bundles only, sets of process.capabilities applied instead of Capabilities,
+	// Capabilities holds bounding set then. Keep leaves capabilities nsInit has when spec has none
+	CapabilitySets   *capabilities.Sets `json:",omitempty"`
+	KeepCapabilities bool               `json:",omitempty"`
+	NoNewPrivileges  bool
+	// kernel paths hidden from container or made read-only
+	MaskedPaths   []string
+	ReadonlyPaths []string
+	// one of network modes above
+	Network string
+	// container mode only, network namespace like /proc/<pid>/ns/net container is started in
+	NetNsPath string `json:",omitempty"`
+	// bridge mode only, interface put into container (nsInit renames it to eth0) and its address in CIDR notation
+	NetworkInterface string
+	IPAddress        string
+	// bridge mode only, default route goes through Gateway (straight through interface when empty), MTU 0 keeps one of interface
+	Gateway string
+	MTU     int
+	// bridge mode on dual-stack network only, IPv6 address in CIDR notation and its gateway
+	IPAddress6 string `json:",omitempty"`
+	Gateway6   string `json:",omitempty"`
+	// rootfs is mounted by nsInit inside user namespace
+	Rootless bool
+	// user namespace mappings, written by parent after fork
+	UIDMappings []syscall.SysProcIDMap
+	GIDMappings []syscall.SysProcIDMap
+}
+
+// Rlimit is resource limit set by setrlimit, Type is RLIMIT_* constant
+type Rlimit struct {
+	Type int
+	Hard uint64
+	Soft uint64
+}
+
+// MergeEnv returns base environment with variables from override replacing ones with same name
+func MergeEnv(base, override []string) []string {
+	var env []string
+	index := map[string]int{}
+	for _, list := range [][]string{base, override} {
+		for _, kv := range list {
+			name := strings.SplitN(kv, "=", 2)[0]
+			if i, ok := index[name]; ok {
+				env[i] = kv
+				continue
+			}
+			index[name] = len(env)
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// SplitCommand splits command line into argv handling quotes and backslashes the way shell does:
+// inside 'single' quotes everything is literal, inside "double" ones backslash escapes only \ " $ and `.
+// Nothing is expanded
+func SplitCommand(line string) ([]string, error) {
+	var (
+		args    []string
+		word    strings.Builder
+		inWord  bool
+		escaped bool
+		quote   rune
+	)
+	for _, r := range line {
+		switch {
+		case escaped:
+			if quote == '"' && !strings.ContainsRune("\\\"$`", r) {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			// "" is empty argument, not nothing
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if escaped || quote != 0 {
+		return nil, fmt.Errorf("unterminated quote or backslash in command %q", line)
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
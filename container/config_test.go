@@ -0,0 +1,47 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeEnv(t *testing.T) {
+	got := MergeEnv(
+		[]string{"PATH=/bin", "HOME=/root", "TERM=xterm"},
+		[]string{"HOME=/home/app", "DEBUG=1", "PATH=/usr/bin:/bin"},
+	)
+	expected := []string{"PATH=/usr/bin:/bin", "HOME=/home/app", "TERM=xterm", "DEBUG=1"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expecting %v, got %v", expected, got)
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	var cases = map[string][]string{
+		"/bin/sh":                       {"/bin/sh"},
+		"  ls   -l\t/tmp ":              {"ls", "-l", "/tmp"},
+		`sh -c 'echo hi there'`:         {"sh", "-c", "echo hi there"},
+		`sh -c "echo \"hi\" \$HOME \n"`: {"sh", "-c", `echo "hi" $HOME \n`},
+		`echo 'it'\''s'`:                {"echo", "it's"},
+		`touch a\ b`:                    {"touch", "a b"},
+		`printf ""`:                     {"printf", ""},
+		`echo '\'`:                      {"echo", `\`},
+		"":                              nil,
+	}
+	for line, expected := range cases {
+		got, err := SplitCommand(line)
+		if err != nil {
+			t.Errorf("For %s got error: %s", line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("For %s expecting %q, got %q", line, expected, got)
+		}
+	}
+
+	for _, line := range []string{`sh -c 'echo`, `echo "hi`, `echo \`} {
+		if got, err := SplitCommand(line); err == nil {
+			t.Errorf("For %s expecting error, got %q", line, got)
+		}
+	}
+}
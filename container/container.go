@@ -1,6 +1,7 @@
 package container
 
 import (
+	"log"
 	"os"
 	"os/exec"
 	"syscall"
@@ -26,45 +27,88 @@ func DownloadAndMount(imageName, containerName string) (string, error) {
 }
 
 //SetNameSpaces sets all required namespaces for the process and execute fork
-func SetNameSpaces(imageName, containerName, command string) (*exec.Cmd, string, error) {
+func SetNameSpaces(imageName, containerName string, config *Config) (*exec.Cmd, string, error) {
+	if config.UIDMappings == nil || config.GIDMappings == nil {
+		uids, gids, err := DefaultIDMappings()
+		if err != nil {
+			return nil, "", err
+		}
+		if config.UIDMappings == nil {
+			config.UIDMappings = uids
+		}
+		if config.GIDMappings == nil {
+			config.GIDMappings = gids
+		}
+	}
+	// layers are unpacked with ownership as seen from inside container
+	storage.SetIDMappings(config.UIDMappings, config.GIDMappings)
+
 	newRoot, err := DownloadAndMount(imageName, containerName)
 	if err != nil {
 		return nil, "", err
 	}
 
-	/*
-	*
-	* Here should be possible to drop root privileges and run in usermode NS directly
-	*
-	 */
+	config.Rootfs = newRoot
 
-	cmd := reexec.Command("nsInit", newRoot, command)
+	if err := applyImageConfig(imageName, config); err != nil {
+		syscall.Unmount(newRoot, 0)
+		return nil, "", err
+	}
+
+	// everything nsInit needs from host has to be ready before fork.
+	// Rootless rootfs is not mounted yet, nsInit populates named volumes from image then
+	if err := prepareMounts(config.Mounts, newRoot, config.Rootless); err != nil {
+		syscall.Unmount(newRoot, 0)
+		return nil, "", err
+	}
+	containerPath := storage.ContainerPath(containerName)
+	config.EtcDir = containerPath
+	if err := writeEtcFiles(containerPath, config.Hostname, config.DNS, config.ExtraHosts); err != nil {
+		syscall.Unmount(newRoot, 0)
+		return nil, "", err
+	}
+
+	if config.CloneFlags == 0 {
+		config.CloneFlags = DefaultCloneFlags
+	}
+	return InitCommand(config), newRoot, nil
+}
+
+// InitCommand returns command forking nsInit in namespaces of config,
+// everything else nsInit gets from config sent by Start
+func InitCommand(config *Config) *exec.Cmd {
+	cmd := reexec.Command("nsInit")
 
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
 	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags: syscall.CLONE_NEWNS |
-			syscall.CLONE_NEWUTS |
-			syscall.CLONE_NEWIPC |
-			syscall.CLONE_NEWPID |
-			syscall.CLONE_NEWNET |
-			syscall.CLONE_NEWUSER,
-		UidMappings: []syscall.SysProcIDMap{
-			{
-				ContainerID: 0,
-				HostID:      os.Getuid(),
-				Size:        1,
-			},
-		},
-		GidMappings: []syscall.SysProcIDMap{
-			{
-				ContainerID: 0,
-				HostID:      os.Getgid(),
-				Size:        1,
-			},
-		},
+		Cloneflags: config.CloneFlags,
+	}
+	return cmd
+}
+
+// image defines user, environment and working directory, values given by user override them
+func applyImageConfig(imageName string, config *Config) error {
+	img, err := registry.ParseImageName(imageName)
+	if err != nil {
+		return err
+	}
+	imgConfig, err := storage.GetImageConfig(img)
+	if err != nil {
+		log.Println("Image config not available, running as root: ", err)
+	} else {
+		if config.User == "" {
+			config.User = imgConfig.Config.User
+		}
+		if config.Cwd == "" {
+			config.Cwd = imgConfig.Config.WorkingDir
+		}
+		config.Env = MergeEnv(imgConfig.Config.Env, config.Env)
+	}
+	if config.Cwd == "" {
+		config.Cwd = "/"
 	}
-	return cmd, newRoot, nil
+	return nil
 }
@@ -0,0 +1,102 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EtcFiles lists files generated per container and bind mounted over /etc/<name> inside its rootfs
+var EtcFiles = []string{"hostname", "hosts", "resolv.conf"}
+
+// used when host resolv.conf is missing or points only to local resolvers (systemd-resolved, dnsmasq)
+// which are unreachable from container network namespace. Same fallback as docker uses.
+var defaultDNS = []string{"8.8.8.8", "8.8.4.4"}
+
+// generates hostname, hosts and resolv.conf in container directory.
+// dns overrides nameservers taken from host, extraHosts are in form host:ip
+func writeEtcFiles(containerPath, hostname string, dns, extraHosts []string) error {
+	if err := ioutil.WriteFile(filepath.Join(containerPath, "hostname"), []byte(hostname+"\n"), 0644); err != nil {
+		return err
+	}
+
+	hosts, err := generateHosts(extraHosts)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(containerPath, "hosts"), hosts, 0644); err != nil {
+		return err
+	}
+
+	resolv, err := generateResolvConf(dns)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(containerPath, "resolv.conf"), resolv, 0644)
+}
+
+func generateHosts(extraHosts []string) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("127.0.0.1\tlocalhost\n")
+	b.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+	b.WriteString("fe00::0\tip6-localnet\n")
+	b.WriteString("ff00::0\tip6-mcastprefix\n")
+	b.WriteString("ff02::1\tip6-allnodes\n")
+	b.WriteString("ff02::2\tip6-allrouters\n")
+	for _, h := range extraHosts {
+		// split on first ":" only, IPv6 addresses contain colons too
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || net.ParseIP(parts[1]) == nil {
+			return nil, fmt.Errorf("invalid --add-host %q, expecting host:ip", h)
+		}
+		fmt.Fprintf(&b, "%s\t%s\n", parts[1], parts[0])
+	}
+	return b.Bytes(), nil
+}
+
+// copy search and options from host resolv.conf, nameservers too unless dns was provided
+func generateResolvConf(dns []string) ([]byte, error) {
+	for _, ns := range dns {
+		if net.ParseIP(ns) == nil {
+			return nil, fmt.Errorf("invalid --dns address %q", ns)
+		}
+	}
+	var (
+		b           bytes.Buffer
+		nameservers []string
+	)
+	if f, err := os.Open("/etc/resolv.conf"); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 2 {
+				continue
+			}
+			switch fields[0] {
+			case "nameserver":
+				// loopback resolvers of the host can't be reached from container network namespace
+				if ip := net.ParseIP(fields[1]); ip != nil && !ip.IsLoopback() {
+					nameservers = append(nameservers, fields[1])
+				}
+			case "search", "options":
+				b.WriteString(strings.Join(fields, " ") + "\n")
+			}
+		}
+	}
+	if len(dns) > 0 {
+		nameservers = dns
+	}
+	if len(nameservers) == 0 {
+		nameservers = defaultDNS
+	}
+	for _, ns := range nameservers {
+		b.WriteString("nameserver " + ns + "\n")
+	}
+	return b.Bytes(), nil
+}
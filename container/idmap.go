@@ -0,0 +1,149 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+/*
+ User namespace of container maps its root to the user running cntcli and ids 1..n
+ to subordinate ranges from /etc/subuid and /etc/subgid, same as podman does.
+ Without ranges only root is mapped, so files owned by other users show up as nobody
+ and chown to them fails.
+ Multiple ranges need newuidmap/newgidmap (setuid helpers from shadow package) unless we are root,
+ that is why mappings are written by parent after fork, child waits for it on sync pipe.
+*/
+
+// ParseIDMap parses explicit mapping in form containerID:hostID:size
+func ParseIDMap(spec string) (syscall.SysProcIDMap, error) {
+	var m syscall.SysProcIDMap
+	parts := strings.Split(spec, ":")
+	if len(parts) != 3 {
+		return m, fmt.Errorf("invalid id mapping %q, expecting containerID:hostID:size", spec)
+	}
+	var values [3]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil || v < 0 {
+			return m, fmt.Errorf("invalid id mapping %q, expecting containerID:hostID:size", spec)
+		}
+		values[i] = v
+	}
+	if values[2] == 0 {
+		return m, fmt.Errorf("invalid id mapping %q, size can't be 0", spec)
+	}
+	return syscall.SysProcIDMap{ContainerID: values[0], HostID: values[1], Size: values[2]}, nil
+}
+
+// DefaultIDMappings maps container root to current user and following ids to its subordinate ranges
+func DefaultIDMappings() (uids, gids []syscall.SysProcIDMap, err error) {
+	name := ""
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	uidRanges, err := parseSubIDs("/etc/subuid", name, os.Getuid())
+	if err != nil {
+		return nil, nil, err
+	}
+	gidRanges, err := parseSubIDs("/etc/subgid", name, os.Getuid())
+	if err != nil {
+		return nil, nil, err
+	}
+	return buildMappings(os.Getuid(), uidRanges), buildMappings(os.Getgid(), gidRanges), nil
+}
+
+func buildMappings(hostID int, ranges [][2]int) []syscall.SysProcIDMap {
+	maps := []syscall.SysProcIDMap{{ContainerID: 0, HostID: hostID, Size: 1}}
+	next := 1
+	for _, r := range ranges {
+		maps = append(maps, syscall.SysProcIDMap{ContainerID: next, HostID: r[0], Size: r[1]})
+		next += r[1]
+	}
+	return maps
+}
+
+// reads start and count of ranges given to user. Lines are name:start:count,
+// user can be listed by name or by uid. Missing file means no ranges
+func parseSubIDs(path, name string, uid int) ([][2]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var ranges [][2]int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 || (fields[0] != name && fields[0] != strconv.Itoa(uid)) {
+			continue
+		}
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid line in %s: %q", path, line)
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid line in %s: %q", path, line)
+		}
+		if count > 0 {
+			ranges = append(ranges, [2]int{start, count})
+		}
+	}
+	return ranges, scanner.Err()
+}
+
+// writes uid and gid maps of process running in new user namespace
+func writeIDMappings(pid int, uids, gids []syscall.SysProcIDMap) error {
+	if err := writeIDMap(pid, "uid_map", "newuidmap", uids, os.Getuid()); err != nil {
+		return fmt.Errorf("writing uid map: %s", err)
+	}
+	if err := writeIDMap(pid, "gid_map", "newgidmap", gids, os.Getgid()); err != nil {
+		return fmt.Errorf("writing gid map: %s", err)
+	}
+	return nil
+}
+
+func writeIDMap(pid int, file, helper string, maps []syscall.SysProcIDMap, ownID int) error {
+	var b bytes.Buffer
+	args := []string{strconv.Itoa(pid)}
+	for _, m := range maps {
+		fmt.Fprintf(&b, "%d %d %d\n", m.ContainerID, m.HostID, m.Size)
+		args = append(args, strconv.Itoa(m.ContainerID), strconv.Itoa(m.HostID), strconv.Itoa(m.Size))
+	}
+	procFile := fmt.Sprintf("/proc/%d/%s", pid, file)
+
+	// root can write any mapping, unprivileged user only single line with its own id
+	if os.Geteuid() == 0 {
+		return ioutil.WriteFile(procFile, b.Bytes(), 0)
+	}
+	if len(maps) == 1 && maps[0].HostID == ownID && maps[0].Size == 1 {
+		// kernel requires setgroups to be denied before unprivileged gid map is written
+		if file == "gid_map" {
+			if err := ioutil.WriteFile(fmt.Sprintf("/proc/%d/setgroups", pid), []byte("deny"), 0); err != nil {
+				return err
+			}
+		}
+		return ioutil.WriteFile(procFile, b.Bytes(), 0)
+	}
+	out, err := exec.Command(helper, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s %s", helper, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
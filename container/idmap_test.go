@@ -0,0 +1,63 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestSubIDMappings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cntcli-subid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	subuid := filepath.Join(dir, "subuid")
+	ioutil.WriteFile(subuid, []byte(`# comment
+app:100000:65536
+other:165536:65536
+1000:300000:1000
+`), 0644)
+
+	ranges, err := parseSubIDs(subuid, "app", 1000)
+	if err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	got := buildMappings(1000, ranges)
+	expected := []syscall.SysProcIDMap{
+		{ContainerID: 0, HostID: 1000, Size: 1},
+		{ContainerID: 1, HostID: 100000, Size: 65536},
+		{ContainerID: 65537, HostID: 300000, Size: 1000},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expecting %v, got %v", expected, got)
+	}
+
+	ranges, err = parseSubIDs(filepath.Join(dir, "missing"), "app", 1000)
+	if err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	if got := buildMappings(1000, ranges); len(got) != 1 {
+		t.Errorf("Expecting only root mapping without subuid file, got %v", got)
+	}
+}
+
+func TestIDMapParsing(t *testing.T) {
+	var cases = map[string]bool{
+		"0:100000:65536": true,
+		"0:1000:1":       true,
+		"0:1000":         false,
+		"0:1000:0":       false,
+		"a:1000:1":       false,
+		"0:-1:1":         false,
+	}
+	for spec, valid := range cases {
+		_, err := ParseIDMap(spec)
+		if (err == nil) != valid {
+			t.Errorf("For %s expecting %v, got %v", spec, valid, err)
+		}
+	}
+}
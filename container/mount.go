@@ -0,0 +1,166 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/odk-/dockerinternals/storage"
+)
+
+// Mount describes host directory or named volume mounted into container
+type Mount struct {
+	// host path, for named volumes filled in by SetNameSpaces
+	Source      string
+	Destination string
+	ReadOnly    bool
+	// name of volume, empty for bind mounts
+	Volume string `json:",omitempty"`
+	// empty volume is filled from image by nsInit, rootless rootfs is mounted only there
+	Populate bool `json:",omitempty"`
+}
+
+// Tmpfs describes tmpfs mounted into container, Data holds options passed to kernel like size=64m
+type Tmpfs struct {
+	Destination string
+	Flags       uintptr
+	Data        string
+}
+
+// FsMount is generic mount of filesystem Type, source is passed to kernel as is
+type FsMount struct {
+	Source      string
+	Destination string
+	Type        string
+	Flags       uintptr
+	Data        string
+}
+
+// mount flags accepted in --tmpfs options, everything else goes to tmpfs itself
+var tmpfsFlags = map[string]struct {
+	clear bool
+	flag  uintptr
+}{
+	"ro":       {false, syscall.MS_RDONLY},
+	"rw":       {true, syscall.MS_RDONLY},
+	"noexec":   {false, syscall.MS_NOEXEC},
+	"exec":     {true, syscall.MS_NOEXEC},
+	"nosuid":   {false, syscall.MS_NOSUID},
+	"suid":     {true, syscall.MS_NOSUID},
+	"nodev":    {false, syscall.MS_NODEV},
+	"dev":      {true, syscall.MS_NODEV},
+	"noatime":  {false, syscall.MS_NOATIME},
+	"atime":    {true, syscall.MS_NOATIME},
+	"relatime": {false, syscall.MS_RELATIME},
+}
+
+// ParseTmpfs parses --tmpfs option in form /path[:size=64m,mode=1777,...].
+// Same as docker noexec, nosuid and nodev are set unless overridden.
+func ParseTmpfs(spec string) (Tmpfs, error) {
+	t := Tmpfs{Flags: syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV}
+	parts := strings.SplitN(spec, ":", 2)
+	if !filepath.IsAbs(parts[0]) {
+		return t, fmt.Errorf("invalid tmpfs spec %q, container path must be absolute", spec)
+	}
+	t.Destination = filepath.Clean(parts[0])
+	if len(parts) == 1 || parts[1] == "" {
+		return t, nil
+	}
+	var data []string
+	for _, opt := range strings.Split(parts[1], ",") {
+		if f, ok := tmpfsFlags[opt]; ok {
+			if f.clear {
+				t.Flags &^= f.flag
+			} else {
+				t.Flags |= f.flag
+			}
+			continue
+		}
+		if !strings.Contains(opt, "=") {
+			return t, fmt.Errorf("invalid tmpfs option %q in %q", opt, spec)
+		}
+		data = append(data, opt)
+	}
+	t.Data = strings.Join(data, ",")
+	return t, nil
+}
+
+// ParseMount parses -v option in form host:container[:ro|rw].
+// Host part not starting with / is treated as named volume.
+func ParseMount(spec string) (Mount, error) {
+	var m Mount
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return m, fmt.Errorf("invalid volume spec %q, expecting host:container[:ro]", spec)
+	}
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "ro":
+			m.ReadOnly = true
+		case "rw":
+		default:
+			return m, fmt.Errorf("invalid volume mode %q in %q", parts[2], spec)
+		}
+	}
+	if parts[0] == "" {
+		return m, fmt.Errorf("invalid volume spec %q, empty host part", spec)
+	}
+	if !filepath.IsAbs(parts[1]) {
+		return m, fmt.Errorf("invalid volume spec %q, container path must be absolute", spec)
+	}
+	m.Destination = filepath.Clean(parts[1])
+	if filepath.IsAbs(parts[0]) {
+		m.Source = filepath.Clean(parts[0])
+	} else {
+		m.Volume = parts[0]
+	}
+	return m, nil
+}
+
+// resolves host side of all mounts. Named volumes are created if missing and
+// filled with image content of the mount point when empty.
+func prepareMounts(mounts []Mount, rootfs string, rootless bool) error {
+	for i := range mounts {
+		m := &mounts[i]
+		if m.Volume == "" {
+			// same as docker: missing host directory gets created
+			if _, err := os.Stat(m.Source); os.IsNotExist(err) {
+				if err := os.MkdirAll(m.Source, 0755); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := storage.CreateVolume(m.Volume); err != nil {
+			return err
+		}
+		m.Source = storage.VolumePath(m.Volume)
+		empty, err := storage.IsVolumeEmpty(m.Volume)
+		if err != nil {
+			return err
+		}
+		if empty && rootless {
+			m.Populate = true
+		} else if empty {
+			if err := storage.PopulateVolume(m.Source, rootfs, m.Destination); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PopulateVolumes fills volumes prepareMounts left for nsInit, rootfs has to be mounted already
+func PopulateVolumes(mounts []Mount, rootfs string) error {
+	for _, m := range mounts {
+		if !m.Populate {
+			continue
+		}
+		if err := storage.PopulateVolume(m.Source, rootfs, m.Destination); err != nil {
+			return fmt.Errorf("volume %s: %s", m.Volume, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package container
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestMountParsing(t *testing.T) {
+	var cases = map[string]Mount{
+		"/srv/data:/data":       {"/srv/data", "/data", false, "", false},
+		"/srv/data:/data:ro":    {"/srv/data", "/data", true, "", false},
+		"/srv/data/:/data/:rw":  {"/srv/data", "/data", false, "", false},
+		"dbdata:/var/lib/db":    {"", "/var/lib/db", false, "dbdata", false},
+		"dbdata:/var/lib/db:ro": {"", "/var/lib/db", true, "dbdata", false},
+	}
+
+	for param, ans := range cases {
+		resp, err := ParseMount(param)
+		if err != nil {
+			t.Error("Got error: ", err)
+		}
+		if resp != ans {
+			t.Errorf("For %s expecting %v, got %v", param, ans, resp)
+		}
+	}
+
+	for _, param := range []string{"/data", "/srv:data", ":/data", "/srv:/data:rx", "a:/b:ro:x"} {
+		if _, err := ParseMount(param); err == nil {
+			t.Errorf("For %s expecting error", param)
+		}
+	}
+}
+
+func TestTmpfsParsing(t *testing.T) {
+	const defaults = syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
+
+	var cases = map[string]Tmpfs{
+		"/run":                      {"/run", defaults, ""},
+		"/tmp/:size=64m,mode=1777":  {"/tmp", defaults, "size=64m,mode=1777"},
+		"/scratch:exec,ro,size=1g":  {"/scratch", syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_RDONLY, "size=1g"},
+		"/dev:dev,suid,mode=755,rw": {"/dev", syscall.MS_NOEXEC, "mode=755"},
+	}
+
+	for param, ans := range cases {
+		resp, err := ParseTmpfs(param)
+		if err != nil {
+			t.Error("Got error: ", err)
+		}
+		if resp != ans {
+			t.Errorf("For %s expecting %v, got %v", param, ans, resp)
+		}
+	}
+
+	for _, param := range []string{"tmp", "/tmp:bogus"} {
+		if _, err := ParseTmpfs(param); err == nil {
+			t.Errorf("For %s expecting error", param)
+		}
+	}
+}
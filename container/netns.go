@@ -0,0 +1,48 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+ Joining network namespace of another container can't be left to nsInit. Namespace is owned
+ by user namespace of that container and nsInit has capabilities only in its own one.
+ Parent is root on host, so it switches its thread into the namespace and forks from there,
+ child inherits it and only other namespaces are created by clone flags.
+*/
+func startInNetNs(cmd *exec.Cmd, path string) error {
+	target, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	// namespace belongs to thread, goroutine must not move while it is switched
+	runtime.LockOSThread()
+	own, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer own.Close()
+	if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("joining network namespace %s: %s", path, err)
+	}
+	startErr := cmd.Start()
+	if err := unix.Setns(int(own.Fd()), unix.CLONE_NEWNET); err != nil {
+		// thread stays locked, nothing else may run in wrong namespace
+		if startErr == nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+		return fmt.Errorf("returning to own network namespace: %s", err)
+	}
+	runtime.UnlockOSThread()
+	return startErr
+}
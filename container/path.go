@@ -0,0 +1,62 @@
+package container
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// symlinks followed by SecureJoin before it gives up, same limit as Linux path resolution
+const maxSymlinks = 40
+
+/*
+ SecureJoin resolves path inside rootfs the way container will see it after pivot_root.
+ Symlinks of the image are followed, but absolute ones start at root and .. stops at it,
+ so result is always under root however links point. Components that don't exist are
+ kept as they are, caller creates them. nsInit is root on host in rootful mode, mount
+ targets must never be created through plain Join.
+*/
+func SecureJoin(root, unsafePath string) (string, error) {
+	// resolved part, absolute as seen from inside root, has no symlinks
+	current := "/"
+	remaining := unsafePath
+	links := 0
+	for remaining != "" {
+		part := remaining
+		remaining = ""
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			part, remaining = part[:i], part[i+1:]
+		}
+		next := filepath.Join(current, part)
+		if next == current || part == ".." {
+			// nothing to resolve, Join keeps .. inside "/"
+			current = next
+			continue
+		}
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if os.IsNotExist(err) {
+			current = next
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", errors.New("too many levels of symbolic links in " + unsafePath)
+		}
+		dest, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(dest) {
+			current = "/"
+		}
+		remaining = dest + "/" + remaining
+	}
+	return filepath.Join(root, current), nil
+}
@@ -0,0 +1,55 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecureJoin(t *testing.T) {
+	root, err := ioutil.TempDir("", "rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "var/lib"), 0755)
+	links := map[string]string{
+		"data":         "/etc/foo",
+		"up":           "../../../../tmp",
+		"var/lib/rel":  "../../srv",
+		"var/lib/self": ".",
+		"loop":         "loop",
+		"etc":          "/var/lib",
+	}
+	for name, dest := range links {
+		if err := os.Symlink(dest, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var cases = map[string]string{
+		"/data":                "/var/lib/foo",
+		"/data/x":              "/var/lib/foo/x",
+		"/up/x":                "/tmp/x",
+		"/../../etc/passwd":    "/var/lib/passwd",
+		"/var/lib/rel/www":     "/srv/www",
+		"/var/lib/self/../lib": "/var/lib",
+		"var//lib/./":          "/var/lib",
+		"/":                    "/",
+	}
+	for path, expected := range cases {
+		got, err := SecureJoin(root, path)
+		if err != nil {
+			t.Errorf("For %s got error: %s", path, err)
+			continue
+		}
+		if got != filepath.Join(root, expected) {
+			t.Errorf("For %s expecting %v, got %v", path, filepath.Join(root, expected), got)
+		}
+	}
+
+	if got, err := SecureJoin(root, "/loop/x"); err == nil {
+		t.Errorf("For /loop/x expecting error, got %s", got)
+	}
+}
@@ -0,0 +1,112 @@
+package container
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/odk-/dockerinternals/network"
+	"github.com/odk-/dockerinternals/storage"
+)
+
+// container statuses kept in state file
+const (
+	// set up and waiting to be started, OCI containers only
+	StatusCreated = "created"
+	StatusRunning = "running"
+	StatusPaused  = "paused"
+	StatusExited  = "exited"
+)
+
+// State describes running or finished container. Stored as state.json in container directory
+type State struct {
+	Name    string
+	Image   string
+	Pid     int
+	Status  string
+	Created time.Time
+	// OCI bundle directory, empty for containers started from image
+	Bundle string `json:",omitempty"`
+	// bridge network container is connected to
+	Network string `json:",omitempty"`
+	// ports published on host while container runs
+	Ports []network.PortMapping `json:",omitempty"`
+}
+
+// SaveState writes container state to disk
+func SaveState(state *State) error {
+	j, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(storage.ContainerPath(state.Name), "state.json"), j, 0644)
+}
+
+// LoadState reads container state from disk. Container whose process is gone
+// (cntcli was killed before it could update state) is reported as exited.
+func LoadState(containerName string) (*State, error) {
+	file, err := ioutil.ReadFile(filepath.Join(storage.ContainerPath(containerName), "state.json"))
+	if err != nil {
+		return nil, err
+	}
+	var state = &State{}
+	err = json.Unmarshal(file, state)
+	if err != nil {
+		return nil, err
+	}
+	if state.Status != StatusExited && !alive(state.Pid) {
+		state.Status = StatusExited
+		state.Pid = 0
+	}
+	return state, nil
+}
+
+// WaitExited polls until process exits, container created by oci create is not our child
+// and can't be waited for
+func WaitExited(pid int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for alive(pid) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("process %d didn't exit in %s", pid, timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// process of container created by oci create is not our child, after it exits
+// it stays zombie until init reaps it
+func alive(pid int) bool {
+	if syscall.Kill(pid, 0) == syscall.ESRCH {
+		return false
+	}
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	// state follows command name in parentheses, name itself can contain ')'
+	i := bytes.LastIndexByte(stat, ')')
+	return i < 0 || i+2 >= len(stat) || stat[i+2] != 'Z'
+}
+
+// ListStates returns states of all containers that were started at least once
+func ListStates() ([]*State, error) {
+	names, err := storage.ListContainers()
+	if err != nil {
+		return nil, err
+	}
+	var states []*State
+	for _, name := range names {
+		state, err := LoadState(name)
+		if err != nil {
+			// only filesystem was prepared (-o) or state is broken, skip it
+			continue
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
@@ -0,0 +1,212 @@
+package container
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+/*
+ Parent and nsInit talk over socket pair passed to child as fd 3.
+ First parent writes single raw byte once uid/gid maps are written. Capabilities in user namespace
+ are computed at exec, child was executed before it had its uid mapped so it has none and
+ has to exec itself again (see WaitForMappings). After that messages are JSON objects:
+	parent -> child: config		full container Config, nsInit knows nothing else
+	parent -> child: network-ready	interfaces are moved into child network namespace
+	child -> parent: created		(only with ExecFifo) setup is done, waiting on fifo to start command
+	child -> parent: ready-to-exec	setup is done, command was started
+	child -> parent: error		setup failed at stage, child exits
+ Child closes its end when command is started, command doesn't inherit it.
+*/
+
+const (
+	syncConfig       = "config"
+	syncNetworkReady = "network-ready"
+	syncCreated      = "created"
+	syncReadyToExec  = "ready-to-exec"
+	syncError        = "error"
+)
+
+// fd of sync socket in nsInit
+const syncFd = 3
+
+type syncMessage struct {
+	Type   string  `json:"type"`
+	Stage  string  `json:"stage,omitempty"`
+	Error  string  `json:"error,omitempty"`
+	Config *Config `json:"config,omitempty"`
+}
+
+// SetupError is failure reported by nsInit
+type SetupError struct {
+	Stage string
+	Err   string
+}
+
+func (e *SetupError) Error() string {
+	return fmt.Sprintf("container setup failed (%s): %s", e.Stage, e.Err)
+}
+
+type syncPipe struct {
+	file *os.File
+	enc  *json.Encoder
+	dec  *json.Decoder
+}
+
+func newSyncPipe(f *os.File) *syncPipe {
+	return &syncPipe{file: f, enc: json.NewEncoder(f), dec: json.NewDecoder(f)}
+}
+
+func (s *syncPipe) send(msg syncMessage) error {
+	return s.enc.Encode(msg)
+}
+
+// reads next message, error reported by other side is returned as SetupError
+func (s *syncPipe) expect(msgType string) (*syncMessage, error) {
+	var msg syncMessage
+	if err := s.dec.Decode(&msg); err != nil {
+		return nil, fmt.Errorf("waiting for %s: %s", msgType, err)
+	}
+	if msg.Type == syncError {
+		return nil, &SetupError{Stage: msg.Stage, Err: msg.Error}
+	}
+	if msg.Type != msgType {
+		return nil, fmt.Errorf("expecting %s, got %s", msgType, msg.Type)
+	}
+	return &msg, nil
+}
+
+// Process is container init process seen from parent
+type Process struct {
+	Cmd  *exec.Cmd
+	sync *syncPipe
+}
+
+// Start forks container process, writes its uid and gid maps and sends it config.
+// Child waits for them and for NetworkReady before it continues with setup
+func Start(cmd *exec.Cmd, config *Config) (*Process, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	parent, child := os.NewFile(uintptr(fds[0]), "sync-parent"), os.NewFile(uintptr(fds[1]), "sync-child")
+	cmd.ExtraFiles = append(cmd.ExtraFiles, child)
+	if config.NetNsPath != "" {
+		err = startInNetNs(cmd, config.NetNsPath)
+	} else {
+		err = cmd.Start()
+	}
+	child.Close()
+	if err != nil {
+		parent.Close()
+		return nil, err
+	}
+	p := &Process{Cmd: cmd, sync: newSyncPipe(parent)}
+
+	if config.CloneFlags&syscall.CLONE_NEWUSER != 0 {
+		if err := writeIDMappings(cmd.Process.Pid, config.UIDMappings, config.GIDMappings); err != nil {
+			p.Kill()
+			return nil, err
+		}
+	}
+	if _, err := parent.Write([]byte{0}); err != nil {
+		p.Kill()
+		return nil, err
+	}
+	if err := p.sync.send(syncMessage{Type: syncConfig, Config: config}); err != nil {
+		p.Kill()
+		return nil, err
+	}
+	return p, nil
+}
+
+// NetworkReady lets child configure its network and finish setup
+func (p *Process) NetworkReady() error {
+	return p.sync.send(syncMessage{Type: syncNetworkReady})
+}
+
+// WaitReady blocks until child starts the command, setup failure comes back as SetupError
+func (p *Process) WaitReady() error {
+	_, err := p.sync.expect(syncReadyToExec)
+	p.sync.file.Close()
+	return err
+}
+
+// WaitCreated blocks until child with ExecFifo finishes setup, it waits on fifo then
+func (p *Process) WaitCreated() error {
+	_, err := p.sync.expect(syncCreated)
+	p.sync.file.Close()
+	return err
+}
+
+// Kill stops container process that failed to start
+func (p *Process) Kill() {
+	p.sync.file.Close()
+	p.Cmd.Process.Kill()
+	p.Cmd.Wait()
+}
+
+// InitSync is nsInit end of sync socket
+type InitSync struct {
+	sync *syncPipe
+}
+
+// WaitForMappings blocks until parent writes id mappings. Socket is read directly,
+// it has to stay open and unread past this byte for nsInit executed again
+func WaitForMappings() error {
+	buf := make([]byte, 1)
+	for {
+		n, err := syscall.Read(syncFd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("waiting for id mappings: %s", err)
+		}
+		if n == 0 {
+			return errors.New("waiting for id mappings: parent closed sync socket")
+		}
+		return nil
+	}
+}
+
+// OpenInitSync takes sync socket inherited from parent and reads container config
+func OpenInitSync() (*InitSync, *Config, error) {
+	// fd is inherited without close on exec flag, command must not get it
+	syscall.CloseOnExec(syncFd)
+	s := &InitSync{sync: newSyncPipe(os.NewFile(syncFd, "sync"))}
+	msg, err := s.sync.expect(syncConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if msg.Config == nil {
+		return nil, nil, errors.New("empty config from parent")
+	}
+	return s, msg.Config, nil
+}
+
+// WaitNetwork blocks until parent is done with network setup
+func (s *InitSync) WaitNetwork() error {
+	_, err := s.sync.expect(syncNetworkReady)
+	return err
+}
+
+// Created tells parent setup is done and we are going to wait on exec fifo
+func (s *InitSync) Created() error {
+	return s.sync.send(syncMessage{Type: syncCreated})
+}
+
+// Ready tells parent command was started and closes socket
+func (s *InitSync) Ready() error {
+	defer s.sync.file.Close()
+	return s.sync.send(syncMessage{Type: syncReadyToExec})
+}
+
+// Fail reports setup error at stage to parent
+func (s *InitSync) Fail(stage string, err error) error {
+	defer s.sync.file.Close()
+	return s.sync.send(syncMessage{Type: syncError, Stage: stage, Error: err.Error()})
+}
@@ -0,0 +1,238 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ExecUser holds credentials container command is started with
+type ExecUser struct {
+	UID    uint32
+	GID    uint32
+	Groups []uint32
+	Home   string
+}
+
+// entry of /etc/passwd or /etc/group
+type idEntry struct {
+	name    string
+	id      uint32
+	gid     uint32
+	home    string
+	members []string
+}
+
+/*
+ LookupUser resolves --user value in form user[:group] against passwd and group files
+ of the container (paths are passed so it can be used after pivot_root and in tests).
+ Both user and group can be a name or numeric id. Numeric ids don't have to exist in files.
+ Empty spec means root.
+*/
+func LookupUser(spec, passwdPath, groupPath string) (*ExecUser, error) {
+	userPart, groupPart := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		userPart, groupPart = spec[:i], spec[i+1:]
+	}
+	if userPart == "" {
+		userPart = "0"
+	}
+	u := &ExecUser{Home: "/"}
+
+	users, err := parseIDFile(passwdPath, parsePasswdLine)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var userName string
+	if uid, err := strconv.ParseUint(userPart, 10, 32); err == nil {
+		u.UID = uint32(uid)
+		for _, e := range users {
+			if e.id == u.UID {
+				userName, u.GID, u.Home = e.name, e.gid, e.home
+				break
+			}
+		}
+	} else {
+		found := false
+		for _, e := range users {
+			if e.name == userPart {
+				userName, u.UID, u.GID, u.Home = e.name, e.id, e.gid, e.home
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unable to find user %s: no matching entries in passwd file", userPart)
+		}
+	}
+
+	if u.Home == "" {
+		u.Home = "/"
+	}
+
+	groups, err := parseIDFile(groupPath, parseGroupLine)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if groupPart != "" {
+		// explicit group replaces primary group and supplementary ones
+		if gid, err := strconv.ParseUint(groupPart, 10, 32); err == nil {
+			u.GID = uint32(gid)
+		} else {
+			found := false
+			for _, g := range groups {
+				if g.name == groupPart {
+					u.GID = g.id
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unable to find group %s: no matching entries in group file", groupPart)
+			}
+		}
+		u.Groups = []uint32{u.GID}
+		return u, nil
+	}
+
+	u.Groups = []uint32{u.GID}
+	for _, g := range groups {
+		if g.id == u.GID {
+			continue
+		}
+		for _, m := range g.members {
+			if userName != "" && m == userName {
+				u.Groups = append(u.Groups, g.id)
+				break
+			}
+		}
+	}
+	return u, nil
+}
+
+// CheckMapped verifies that user and primary group ids exist in user namespace of calling
+// process, otherwise setuid fails with not very helpful EINVAL. Supplementary groups that
+// are not mapped are dropped, root is member of wheel and others it usually has no range for
+func (u *ExecUser) CheckMapped() error {
+	uids, err := readIDMap("/proc/self/uid_map")
+	if err != nil {
+		return err
+	}
+	if !idMapped(u.UID, uids) {
+		return fmt.Errorf("id %d is not mapped in container user namespace (/proc/self/uid_map)", u.UID)
+	}
+	gids, err := readIDMap("/proc/self/gid_map")
+	if err != nil {
+		return err
+	}
+	if !idMapped(u.GID, gids) {
+		return fmt.Errorf("id %d is not mapped in container user namespace (/proc/self/gid_map)", u.GID)
+	}
+	u.dropGroups(gids)
+	return nil
+}
+
+// DropUnmappedGroups removes supplementary groups missing in gid mappings of new user namespace
+func (u *ExecUser) DropUnmappedGroups(gids []syscall.SysProcIDMap) {
+	var ranges [][2]uint64
+	for _, m := range gids {
+		ranges = append(ranges, [2]uint64{uint64(m.ContainerID), uint64(m.ContainerID) + uint64(m.Size)})
+	}
+	u.dropGroups(ranges)
+}
+
+func (u *ExecUser) dropGroups(ranges [][2]uint64) {
+	groups := u.Groups[:0]
+	for _, g := range u.Groups {
+		if idMapped(g, ranges) {
+			groups = append(groups, g)
+		}
+	}
+	u.Groups = groups
+}
+
+// reads [start, end) ranges of ids inside namespace from uid_map or gid_map
+func readIDMap(file string) ([][2]uint64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ranges [][2]uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// inside-id outside-id length
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		start, _ := strconv.ParseUint(fields[0], 10, 32)
+		length, _ := strconv.ParseUint(fields[2], 10, 32)
+		ranges = append(ranges, [2]uint64{start, start + length})
+	}
+	return ranges, scanner.Err()
+}
+
+func idMapped(id uint32, ranges [][2]uint64) bool {
+	for _, r := range ranges {
+		if uint64(id) >= r[0] && uint64(id) < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func parseIDFile(path string, parse func([]string) (idEntry, bool)) ([]idEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []idEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if e, ok := parse(strings.Split(line, ":")); ok {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// name:password:uid:gid:gecos:home:shell
+func parsePasswdLine(fields []string) (idEntry, bool) {
+	if len(fields) < 6 {
+		return idEntry{}, false
+	}
+	uid, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil {
+		return idEntry{}, false
+	}
+	gid, err := strconv.ParseUint(fields[3], 10, 32)
+	if err != nil {
+		return idEntry{}, false
+	}
+	return idEntry{name: fields[0], id: uint32(uid), gid: uint32(gid), home: fields[5]}, true
+}
+
+// name:password:gid:member,member
+func parseGroupLine(fields []string) (idEntry, bool) {
+	if len(fields) < 3 {
+		return idEntry{}, false
+	}
+	gid, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil {
+		return idEntry{}, false
+	}
+	e := idEntry{name: fields[0], id: uint32(gid)}
+	if len(fields) > 3 && fields[3] != "" {
+		e.members = strings.Split(fields[3], ",")
+	}
+	return e, true
+}
@@ -0,0 +1,83 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestUserLookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cntcli-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwd := filepath.Join(dir, "passwd")
+	group := filepath.Join(dir, "group")
+	ioutil.WriteFile(passwd, []byte(`root:x:0:0:root:/root:/bin/sh
+# comment
+nobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin
+app:x:1000:1000::/home/app:/bin/sh
+`), 0644)
+	ioutil.WriteFile(group, []byte(`root:x:0:
+wheel:x:10:root,app
+audio:x:29:app
+nogroup:x:65534:
+app:x:1000:
+`), 0644)
+
+	var cases = map[string]ExecUser{
+		"":             {0, 0, []uint32{0, 10}, "/root"},
+		"root":         {0, 0, []uint32{0, 10}, "/root"},
+		"app":          {1000, 1000, []uint32{1000, 10, 29}, "/home/app"},
+		"1000":         {1000, 1000, []uint32{1000, 10, 29}, "/home/app"},
+		"app:audio":    {1000, 29, []uint32{29}, "/home/app"},
+		"nobody:1234":  {65534, 1234, []uint32{1234}, "/nonexistent"},
+		"4242":         {4242, 0, []uint32{0}, "/"},
+		"4242:nogroup": {4242, 65534, []uint32{65534}, "/"},
+	}
+	for param, ans := range cases {
+		resp, err := LookupUser(param, passwd, group)
+		if err != nil {
+			t.Errorf("For %s got error: %s", param, err)
+			continue
+		}
+		if !reflect.DeepEqual(*resp, ans) {
+			t.Errorf("For %s expecting %v, got %v", param, ans, *resp)
+		}
+	}
+
+	for _, param := range []string{"ghost", "app:ghosts"} {
+		if _, err := LookupUser(param, passwd, group); err == nil {
+			t.Errorf("For %s expecting error", param)
+		}
+	}
+
+	// image without passwd still works with numeric ids
+	resp, err := LookupUser("1:2", filepath.Join(dir, "missing"), filepath.Join(dir, "missing"))
+	if err != nil || resp.UID != 1 || resp.GID != 2 {
+		t.Errorf("Expecting 1:2 without passwd file, got %v %v", resp, err)
+	}
+}
+
+func TestDropUnmappedGroups(t *testing.T) {
+	// root mapped alone and app with subordinate range
+	gids := []syscall.SysProcIDMap{{ContainerID: 0, HostID: 1000, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 1000}}
+	var cases = map[string][2][]uint32{
+		"root":      {{0, 10}, {0, 10}},
+		"wheel":     {{0, 1, 2, 10}, {0, 1, 2, 10}},
+		"unmapped":  {{0, 2000, 65534}, {0}},
+		"app":       {{999, 2000, 10}, {999, 10}},
+		"no groups": {{}, {}},
+	}
+	for name, c := range cases {
+		u := &ExecUser{Groups: append([]uint32{}, c[0]...)}
+		u.DropUnmappedGroups(gids)
+		if !reflect.DeepEqual(u.Groups, c[1]) {
+			t.Errorf("For %s expecting %v, got %v", name, c[1], u.Groups)
+		}
+	}
+}
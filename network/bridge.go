@@ -0,0 +1,411 @@
+package network
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+/*
+ Bridge networks are kept as name.json files in configPath. Definition is all we persist,
+ bridge itself is created on first use (and again after reboot) by EnsureBridge.
+ Containers of one network are connected to its bridge, gateway address of the bridge
+ is their default gateway. Network with IPv6 subnet is dual-stack, bridge and containers
+ get address from both subnets. Macvlan and ipvlan networks have no bridge, containers
+ get link on parent interface instead, see vlan.go.
+*/
+
+// DefaultNetwork is used by containers unless --network says otherwise, created on first use
+const DefaultNetwork = "bridge"
+
+// default network keeps addresses containers had before networks could be configured
+const (
+	defaultBridge = "cnt0"
+	defaultSubnet = "192.168.99.0/24"
+)
+
+// subnets offered when network is created without one, 192.168.N.0/24 from defaultSubnet up
+const (
+	poolFirst = 99
+	poolLast  = 254
+)
+
+// same rules as for volume names
+var networkNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// --network takes these as network modes, not names
+var reservedNames = map[string]bool{"none": true, "host": true}
+
+var configPath = "/tmp/cme/networks"
+
+// SetConfigPath configures directory holding network definitions
+func SetConfigPath(path string) {
+	configPath = path
+}
+
+// Network is named network containers can be connected to
+type Network struct {
+	Name string
+	// DriverBridge when empty
+	Driver string `json:",omitempty"`
+	// name of bridge interface on host, empty for macvlan and ipvlan
+	Bridge string
+	// macvlan and ipvlan only, host interface containers appear on and mode of driver
+	Parent string `json:",omitempty"`
+	Mode   string `json:",omitempty"`
+	// subnet in CIDR notation, gateway is address of the bridge in it
+	Subnet  string
+	Gateway string
+	// MTU of bridge and container interfaces, 0 means DefaultMTU (MTU of parent for macvlan and ipvlan)
+	MTU int `json:",omitempty"`
+	// IPv6 subnet and gateway, empty for IPv4 only network
+	Subnet6  string `json:",omitempty"`
+	Gateway6 string `json:",omitempty"`
+	// outbound IPv6 is masqueraded too, without it subnet has to be routed to the host
+	NAT6 bool `json:",omitempty"`
+}
+
+// Options of new network, zero values pick defaults
+type Options struct {
+	// empty is DriverBridge, others need Parent, Mode is driver specific
+	Driver string
+	Parent string
+	Mode   string
+	// empty subnet picks free 192.168.N.0/24, empty gateway is first address of subnet
+	Subnet  string
+	Gateway string
+	// 0 is DefaultMTU
+	MTU int
+	// IPv6 subnet makes network dual-stack, empty gateway is first address of it
+	Subnet6  string
+	Gateway6 string
+	NAT6     bool
+}
+
+// DefaultMTU is MTU of networks created without one, same as ethernet
+const DefaultMTU = 1500
+
+// LinkMTU returns MTU interfaces of network use, 0 for macvlan or ipvlan one keeping MTU of parent
+func (n *Network) LinkMTU() int {
+	if n.MTU > 0 || !n.IsBridge() {
+		return n.MTU
+	}
+	return DefaultMTU
+}
+
+// IsBridge tells if containers are connected to bridge of network
+func (n *Network) IsBridge() bool {
+	return n.Driver == "" || n.Driver == DriverBridge
+}
+
+// Prefix returns subnet mask length of network
+func (n *Network) Prefix() int {
+	return prefixLen(n.Subnet)
+}
+
+// Prefix6 returns IPv6 subnet mask length of network, 0 for IPv4 only one
+func (n *Network) Prefix6() int {
+	return prefixLen(n.Subnet6)
+}
+
+func prefixLen(cidr string) int {
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return 0
+	}
+	ones, _ := subnet.Mask.Size()
+	return ones
+}
+
+func networkFile(name string) string {
+	return filepath.Join(configPath, name+".json")
+}
+
+// CreateNetwork defines new network, bridge by default or macvlan and ipvlan on parent interface
+func CreateNetwork(name string, opts Options) (*Network, error) {
+	if !networkNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("invalid network name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	if reservedNames[name] {
+		return nil, fmt.Errorf("network name %s is reserved", name)
+	}
+	if _, err := os.Stat(networkFile(name)); err == nil {
+		return nil, fmt.Errorf("network %s already exists", name)
+	}
+	existing, err := ListNetworks()
+	if err != nil {
+		return nil, err
+	}
+	subnet := opts.Subnet
+	if subnet == "" {
+		// addresses of LAN are not ours to pick
+		if opts.Driver != "" && opts.Driver != DriverBridge {
+			return nil, fmt.Errorf("%s network needs subnet of %s", opts.Driver, opts.Parent)
+		}
+		if subnet, err = freeSubnet(existing); err != nil {
+			return nil, err
+		}
+	}
+	n, err := newNetwork(name, bridgeName(name), subnet, opts.Gateway)
+	if err != nil {
+		return nil, err
+	}
+	// IPv4 needs at least 68, IPv6 1280
+	minMTU := 68
+	if opts.Subnet6 != "" {
+		minMTU = 1280
+	}
+	if opts.MTU != 0 && (opts.MTU < minMTU || opts.MTU > 65535) {
+		return nil, fmt.Errorf("invalid MTU %d", opts.MTU)
+	}
+	n.MTU = opts.MTU
+	if opts.Subnet6 != "" {
+		if n.Subnet6, n.Gateway6, err = parseSubnet6(opts.Subnet6, opts.Gateway6); err != nil {
+			return nil, err
+		}
+		n.NAT6 = opts.NAT6
+	} else if opts.Gateway6 != "" || opts.NAT6 {
+		return nil, errors.New("IPv6 gateway and NAT need IPv6 subnet")
+	}
+	if err := n.setDriver(opts); err != nil {
+		return nil, err
+	}
+	for _, e := range existing {
+		if overlaps(n.Subnet, e.Subnet) {
+			return nil, fmt.Errorf("subnet %s overlaps with network %s (%s)", n.Subnet, e.Name, e.Subnet)
+		}
+		if n.Subnet6 != "" && overlaps(n.Subnet6, e.Subnet6) {
+			return nil, fmt.Errorf("subnet %s overlaps with network %s (%s)", n.Subnet6, e.Name, e.Subnet6)
+		}
+	}
+	return n, n.save()
+}
+
+// validates subnet and gateway, subnet is stored in canonical form
+func newNetwork(name, bridge, subnet, gateway string) (*Network, error) {
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subnet %q: %s", subnet, err)
+	}
+	ip4 := ipnet.IP.To4()
+	if ip4 == nil {
+		return nil, fmt.Errorf("subnet %s is not IPv4", subnet)
+	}
+	if ones, _ := ipnet.Mask.Size(); ones > 30 {
+		return nil, fmt.Errorf("subnet %s is too small", subnet)
+	}
+	if gateway == "" {
+		gw := make(net.IP, len(ip4))
+		copy(gw, ip4)
+		gw[3]++
+		gateway = gw.String()
+	}
+	gw := net.ParseIP(gateway)
+	if gw == nil || !ipnet.Contains(gw) {
+		return nil, fmt.Errorf("gateway %s is not in subnet %s", gateway, ipnet)
+	}
+	return &Network{Name: name, Bridge: bridge, Subnet: ipnet.String(), Gateway: gw.String()}, nil
+}
+
+// validates IPv6 subnet and gateway, returns them in canonical form
+func parseSubnet6(subnet, gateway string) (string, string, error) {
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid subnet %q: %s", subnet, err)
+	}
+	if ipnet.IP.To4() != nil {
+		return "", "", fmt.Errorf("subnet %s is not IPv6", subnet)
+	}
+	if ones, _ := ipnet.Mask.Size(); ones > 126 {
+		return "", "", fmt.Errorf("subnet %s is too small", subnet)
+	}
+	// subnet address itself is subnet-router anycast, first usable one is gateway
+	gw := nextIP(ipnet.IP)
+	if gateway != "" {
+		if gw = net.ParseIP(gateway); gw == nil || !ipnet.Contains(gw) || gw.Equal(ipnet.IP) {
+			return "", "", fmt.Errorf("gateway %s is not in subnet %s", gateway, ipnet)
+		}
+	}
+	return ipnet.String(), gw.String(), nil
+}
+
+// bridge of network has to be unique and fit into 15 characters, same as docker uses br-<id>
+func bridgeName(network string) string {
+	sum := sha256.Sum256([]byte(network))
+	return "br-" + hex.EncodeToString(sum[:])[:12]
+}
+
+func freeSubnet(existing []*Network) (string, error) {
+	for i := poolFirst; i <= poolLast; i++ {
+		subnet := fmt.Sprintf("192.168.%d.0/24", i)
+		free := true
+		for _, e := range existing {
+			if overlaps(subnet, e.Subnet) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return subnet, nil
+		}
+	}
+	return "", errors.New("no free subnet left, use -subnet")
+}
+
+func overlaps(a, b string) bool {
+	_, na, errA := net.ParseCIDR(a)
+	_, nb, errB := net.ParseCIDR(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return na.Contains(nb.IP) || nb.Contains(na.IP)
+}
+
+func (n *Network) save() error {
+	j, err := json.MarshalIndent(n, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(configPath, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(networkFile(n.Name), j, 0644)
+}
+
+// LoadNetwork reads network definition. Default network is defined on first use
+func LoadNetwork(name string) (*Network, error) {
+	file, err := ioutil.ReadFile(networkFile(name))
+	if os.IsNotExist(err) && name == DefaultNetwork {
+		n, err := newNetwork(DefaultNetwork, defaultBridge, defaultSubnet, "")
+		if err != nil {
+			return nil, err
+		}
+		return n, n.save()
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such network: %s", name)
+		}
+		return nil, err
+	}
+	var n = &Network{}
+	if err := json.Unmarshal(file, n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// ListNetworks returns all defined networks sorted by name, default one is always there
+func ListNetworks() ([]*Network, error) {
+	if _, err := LoadNetwork(DefaultNetwork); err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var networks []*Network
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		n, err := LoadNetwork(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, n)
+	}
+	sort.Slice(networks, func(i, j int) bool { return networks[i].Name < networks[j].Name })
+	return networks, nil
+}
+
+// RemoveNetwork deletes bridge of network, its NAT rules, definition and leases. Default network can't be removed
+func RemoveNetwork(name string) error {
+	if name == DefaultNetwork {
+		return fmt.Errorf("default network %s can't be removed", name)
+	}
+	n, err := LoadNetwork(name)
+	if err != nil {
+		return err
+	}
+	if err := DisableNAT(n); err != nil {
+		return err
+	}
+	// links of macvlan and ipvlan containers are gone with their namespaces
+	if n.IsBridge() {
+		link, err := netlink.LinkByName(n.Bridge)
+		if err == nil {
+			if err := netlink.LinkDel(link); err != nil {
+				return err
+			}
+		} else if _, ok := err.(netlink.LinkNotFoundError); !ok {
+			return err
+		}
+	}
+	if err := os.Remove(leaseFile(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(networkFile(name))
+}
+
+// EnsureBridge creates bridge of network if it is missing, sets gateway address on it and brings it up
+func EnsureBridge(n *Network) (*netlink.Bridge, error) {
+	var bridge *netlink.Bridge
+	link, err := netlink.LinkByName(n.Bridge)
+	switch err.(type) {
+	case nil:
+		var ok bool
+		if bridge, ok = link.(*netlink.Bridge); !ok {
+			return nil, fmt.Errorf("%s exists and is not a bridge", n.Bridge)
+		}
+	case netlink.LinkNotFoundError:
+		la := netlink.NewLinkAttrs()
+		la.Name = n.Bridge
+		bridge = &netlink.Bridge{LinkAttrs: la}
+		if err := netlink.LinkAdd(bridge); err != nil {
+			return nil, fmt.Errorf("creating bridge %s: %s", n.Bridge, err)
+		}
+	default:
+		return nil, err
+	}
+
+	addr, err := netlink.ParseAddr(fmt.Sprintf("%s/%d", n.Gateway, n.Prefix()))
+	if err != nil {
+		return nil, err
+	}
+	// replace doesn't fail when address is already there
+	if err := netlink.AddrReplace(bridge, addr); err != nil {
+		return nil, fmt.Errorf("setting address of %s: %s", n.Bridge, err)
+	}
+	if n.Subnet6 != "" {
+		addr6, err := netlink.ParseAddr(fmt.Sprintf("%s/%d", n.Gateway6, n.Prefix6()))
+		if err != nil {
+			return nil, err
+		}
+		// containers use it as gateway right away, duplicate address detection would hold it back
+		addr6.Flags = unix.IFA_F_NODAD
+		if err := netlink.AddrReplace(bridge, addr6); err != nil {
+			return nil, fmt.Errorf("setting IPv6 address of %s: %s", n.Bridge, err)
+		}
+	}
+	// bridge MTU follows its ports, empty one has to be set explicitly
+	if err := netlink.LinkSetMTU(bridge, n.LinkMTU()); err != nil {
+		return nil, fmt.Errorf("setting MTU of %s: %s", n.Bridge, err)
+	}
+	if err := netlink.LinkSetUp(bridge); err != nil {
+		return nil, err
+	}
+	return bridge, nil
+}
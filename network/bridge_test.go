@@ -0,0 +1,148 @@
+package network
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCreateNetwork(t *testing.T) {
+	dir, err := ioutil.TempDir("", "networks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	SetConfigPath(dir)
+
+	var cases = map[string]struct {
+		subnet, gateway string
+		want            *Network
+	}{
+		"auto":   {"", "", &Network{Subnet: "192.168.100.0/24", Gateway: "192.168.100.1"}},
+		"custom": {"10.10.0.5/16", "10.10.0.254", &Network{Subnet: "10.10.0.0/16", Gateway: "10.10.0.254"}},
+	}
+	for name, c := range cases {
+		n, err := CreateNetwork(name, Options{Subnet: c.subnet, Gateway: c.gateway})
+		if err != nil {
+			t.Fatal("Got error: ", err)
+		}
+		if n.Subnet != c.want.Subnet || n.Gateway != c.want.Gateway || n.Bridge != bridgeName(name) {
+			t.Errorf("For %s expecting %+v, got %+v", name, c.want, n)
+		}
+	}
+
+	var invalid = map[string][2]string{
+		"overlap":    {"10.10.20.0/24", ""},
+		"gateway":    {"10.20.0.0/24", "10.30.0.1"},
+		"not subnet": {"10.20.0.0", ""},
+		"auto":       {"", ""},
+	}
+	for name, c := range invalid {
+		if _, err := CreateNetwork(name, Options{Subnet: c[0], Gateway: c[1]}); err == nil {
+			t.Errorf("For %s expecting error", name)
+		}
+	}
+
+	networks, err := ListNetworks()
+	if err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	// default one is defined on first use, list is sorted by name
+	if len(networks) != 3 {
+		t.Fatalf("Expecting 3 networks, got %d", len(networks))
+	}
+	if networks[1].Name != DefaultNetwork || networks[1].Subnet != defaultSubnet {
+		t.Errorf("Unexpected default network %+v", networks[1])
+	}
+}
+
+func TestCreateNetworkDualStack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "networks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	SetConfigPath(dir)
+
+	var cases = map[string]struct {
+		opts Options
+		want *Network
+	}{
+		"auto":   {Options{Subnet6: "fd00:1::/64"}, &Network{Subnet6: "fd00:1::/64", Gateway6: "fd00:1::1"}},
+		"custom": {Options{Subnet6: "fd00:2::5/48", Gateway6: "fd00:2::ff", NAT6: true}, &Network{Subnet6: "fd00:2::/48", Gateway6: "fd00:2::ff", NAT6: true}},
+	}
+	for name, c := range cases {
+		n, err := CreateNetwork(name, c.opts)
+		if err != nil {
+			t.Fatal("Got error: ", err)
+		}
+		if n.Subnet6 != c.want.Subnet6 || n.Gateway6 != c.want.Gateway6 || n.NAT6 != c.want.NAT6 {
+			t.Errorf("For %s expecting %+v, got %+v", name, c.want, n)
+		}
+	}
+
+	var invalid = map[string]Options{
+		"overlap":    {Subnet6: "fd00:1::/80"},
+		"ipv4":       {Subnet6: "10.50.0.0/24"},
+		"gateway":    {Subnet6: "fd00:3::/64", Gateway6: "fd00:4::1"},
+		"anycast":    {Subnet6: "fd00:3::/64", Gateway6: "fd00:3::"},
+		"small":      {Subnet6: "fd00:3::/127"},
+		"no subnet6": {Gateway6: "fd00:3::1"},
+		"mtu":        {Subnet6: "fd00:3::/64", MTU: 1000},
+	}
+	for name, opts := range invalid {
+		if _, err := CreateNetwork(name, opts); err == nil {
+			t.Errorf("For %s expecting error", name)
+		}
+	}
+}
+
+func TestCreateNetworkDrivers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "networks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	SetConfigPath(dir)
+
+	// parent has to exist, lo is everywhere
+	var cases = map[string]struct {
+		opts Options
+		want *Network
+	}{
+		"mv":  {Options{Driver: DriverMacvlan, Parent: "lo", Subnet: "10.1.0.0/24"}, &Network{Driver: DriverMacvlan, Parent: "lo", Mode: "bridge", Gateway: "10.1.0.1"}},
+		"iv":  {Options{Driver: DriverIPvlan, Parent: "lo", Subnet: "10.2.0.0/24", Gateway: "10.2.0.254"}, &Network{Driver: DriverIPvlan, Parent: "lo", Mode: "l2", Gateway: "10.2.0.254"}},
+		"iv3": {Options{Driver: DriverIPvlan, Parent: "lo", Mode: "l3", Subnet: "10.3.0.0/24", Subnet6: "fd00:3::/64"}, &Network{Driver: DriverIPvlan, Parent: "lo", Mode: "l3"}},
+		"br":  {Options{Driver: DriverBridge, Subnet: "10.4.0.0/24"}, &Network{Bridge: bridgeName("br"), Gateway: "10.4.0.1"}},
+	}
+	for name, c := range cases {
+		n, err := CreateNetwork(name, c.opts)
+		if err != nil {
+			t.Fatalf("For %s got error: %s", name, err)
+		}
+		if n.Driver != c.want.Driver || n.Parent != c.want.Parent || n.Mode != c.want.Mode ||
+			n.Bridge != c.want.Bridge || n.Gateway != c.want.Gateway || n.Gateway6 != c.want.Gateway6 {
+			t.Errorf("For %s expecting %+v, got %+v", name, c.want, n)
+		}
+	}
+	if n, err := LoadNetwork("iv3"); err != nil || n.IsBridge() || n.LinkMTU() != 0 {
+		t.Errorf("Unexpected ipvlan network %+v %v", n, err)
+	}
+
+	var invalid = map[string]Options{
+		"no parent":     {Driver: DriverMacvlan, Subnet: "10.10.0.0/24"},
+		"no subnet":     {Driver: DriverMacvlan, Parent: "lo"},
+		"missing":       {Driver: DriverMacvlan, Parent: "nosuchif0", Subnet: "10.10.0.0/24"},
+		"driver":        {Driver: "overlay", Parent: "lo", Subnet: "10.10.0.0/24"},
+		"macvlan mode":  {Driver: DriverMacvlan, Parent: "lo", Mode: "l3", Subnet: "10.10.0.0/24"},
+		"ipvlan mode":   {Driver: DriverIPvlan, Parent: "lo", Mode: "vepa", Subnet: "10.10.0.0/24"},
+		"nat6":          {Driver: DriverMacvlan, Parent: "lo", Subnet: "10.10.0.0/24", Subnet6: "fd00:10::/64", NAT6: true},
+		"l3 gateway":    {Driver: DriverIPvlan, Parent: "lo", Mode: "l3", Subnet: "10.10.0.0/24", Gateway: "10.10.0.1"},
+		"bridge parent": {Parent: "lo"},
+	}
+	for name, opts := range invalid {
+		if _, err := CreateNetwork(name, opts); err == nil {
+			t.Errorf("For %s expecting error", name)
+		}
+	}
+}
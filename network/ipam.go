@@ -0,0 +1,224 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+/*
+ Addresses of containers are leased from subnet of their network. Leases of network are kept
+ in name.leases next to its definition as JSON object address -> container. Several cntcli
+ processes can start containers at once, so file is flock-ed for whole read-modify-write.
+ Lease belongs to container name, container started again gets the same address
+ until it is removed. Container on dual-stack network has lease in both subnets.
+*/
+
+func leaseFile(name string) string {
+	return filepath.Join(configPath, name+".leases")
+}
+
+// runs update on leases of network with lock held, changes are saved when it succeeds
+func withLeases(n *Network, update func(leases map[string]string) error) error {
+	f, err := os.OpenFile(leaseFile(n.Name), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	// lock is released on close
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("locking leases of %s: %s", n.Name, err)
+	}
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	leases := make(map[string]string)
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, &leases); err != nil {
+			return fmt.Errorf("broken leases of %s: %s", n.Name, err)
+		}
+	}
+	if err := update(leases); err != nil {
+		return err
+	}
+	j, err := json.MarshalIndent(leases, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err = f.WriteAt(j, 0)
+	return err
+}
+
+/*
+ Allocate leases address of network to container and returns it in CIDR notation.
+ requested can be address with or without prefix, empty means first free one.
+ Network and broadcast addresses and gateway are never given out.
+*/
+func Allocate(n *Network, containerName, requested string) (string, error) {
+	return allocate(n, n.Subnet, n.Gateway, containerName, requested)
+}
+
+// Allocate6 is Allocate for IPv6 subnet of dual-stack network
+func Allocate6(n *Network, containerName, requested string) (string, error) {
+	if n.Subnet6 == "" {
+		return "", fmt.Errorf("network %s has no IPv6 subnet", n.Name)
+	}
+	return allocate(n, n.Subnet6, n.Gateway6, containerName, requested)
+}
+
+// leases of both families share one file, container has one address in each subnet
+func allocate(n *Network, cidr, gatewayIP, containerName, requested string) (string, error) {
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	var want net.IP
+	if requested != "" {
+		if want, err = parseRequested(requested, subnet); err != nil {
+			return "", err
+		}
+	}
+	ones, bits := subnet.Mask.Size()
+	first := new(big.Int).Add(ipToInt(subnet.IP), big.NewInt(1))
+	last := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	last.Sub(last, big.NewInt(1)).Or(last, ipToInt(subnet.IP))
+	// IPv6 has no broadcast address
+	if bits == 32 {
+		last.Sub(last, big.NewInt(1))
+	}
+	gateway := net.ParseIP(gatewayIP).String()
+
+	var address string
+	err = withLeases(n, func(leases map[string]string) error {
+		own := ""
+		for ip, c := range leases {
+			if c == containerName && subnet.Contains(net.ParseIP(ip)) {
+				own = ip
+			}
+		}
+		if want != nil {
+			ip := want.String()
+			value := ipToInt(want)
+			if ip == gateway || value.Cmp(first) < 0 || value.Cmp(last) > 0 {
+				return fmt.Errorf("address %s can't be used in network %s", ip, n.Name)
+			}
+			if c, ok := leases[ip]; ok && c != containerName {
+				return fmt.Errorf("address %s is already used by %s", ip, c)
+			}
+			delete(leases, own)
+			leases[ip], address = containerName, ip
+			return nil
+		}
+		if own != "" {
+			address = own
+			return nil
+		}
+		for value := first; value.Cmp(last) <= 0; value.Add(value, big.NewInt(1)) {
+			ip := intToIP(value, bits).String()
+			if _, ok := leases[ip]; ok || ip == gateway {
+				continue
+			}
+			leases[ip], address = containerName, ip
+			return nil
+		}
+		return fmt.Errorf("no free address left in network %s", n.Name)
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%d", address, ones), nil
+}
+
+// accepts 10.0.0.5 and 10.0.0.5/24, prefix has to match subnet
+func parseRequested(requested string, subnet *net.IPNet) (net.IP, error) {
+	ip := net.ParseIP(requested)
+	if strings.Contains(requested, "/") {
+		var ipnet *net.IPNet
+		var err error
+		if ip, ipnet, err = net.ParseCIDR(requested); err != nil {
+			return nil, err
+		}
+		if ipnet.String() != subnet.String() {
+			return nil, fmt.Errorf("address %s is not in subnet %s", requested, subnet)
+		}
+	}
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", requested)
+	}
+	if !subnet.Contains(ip) {
+		return nil, fmt.Errorf("address %s is not in subnet %s", requested, subnet)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4, nil
+	}
+	return ip, nil
+}
+
+// Release gives up address of container in network, container without lease is not an error
+func Release(n *Network, containerName string) error {
+	return withLeases(n, func(leases map[string]string) error {
+		for ip, c := range leases {
+			if c == containerName {
+				delete(leases, ip)
+			}
+		}
+		return nil
+	})
+}
+
+// ReleaseAddress gives up single address, with or without prefix, if container holds it
+func ReleaseAddress(n *Network, containerName, address string) error {
+	ip, _, err := net.ParseCIDR(address)
+	if err != nil {
+		if ip = net.ParseIP(address); ip == nil {
+			return fmt.Errorf("invalid address %q", address)
+		}
+	}
+	return withLeases(n, func(leases map[string]string) error {
+		if leases[ip.String()] == containerName {
+			delete(leases, ip.String())
+		}
+		return nil
+	})
+}
+
+// Leases returns addresses of network with containers holding them
+func Leases(n *Network) (map[string]string, error) {
+	var result map[string]string
+	err := withLeases(n, func(leases map[string]string) error {
+		result = leases
+		return nil
+	})
+	return result, err
+}
+
+func ipToInt(ip net.IP) *big.Int {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return new(big.Int).SetBytes(ip)
+}
+
+// bits is 32 for IPv4 and 128 for IPv6 address
+func intToIP(value *big.Int, bits int) net.IP {
+	return value.FillBytes(make(net.IP, bits/8))
+}
+
+// address following ip
+func nextIP(ip net.IP) net.IP {
+	bits := 128
+	if ip.To4() != nil {
+		bits = 32
+	}
+	return intToIP(new(big.Int).Add(ipToInt(ip), big.NewInt(1)), bits)
+}
@@ -0,0 +1,166 @@
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestAllocate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "networks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	SetConfigPath(dir)
+	n := &Network{Name: "test", Subnet: "10.1.0.0/29", Gateway: "10.1.0.1"}
+
+	// steps run in order, each one sees leases of previous ones
+	var steps = []struct {
+		container, requested, want string
+	}{
+		{"a", "", "10.1.0.2/29"},
+		{"b", "", "10.1.0.3/29"},
+		{"a", "", "10.1.0.2/29"},
+		{"c", "10.1.0.6", "10.1.0.6/29"},
+		{"b", "10.1.0.5/29", "10.1.0.5/29"},
+		{"d", "", "10.1.0.3/29"},
+		{"e", "", "10.1.0.4/29"},
+	}
+	for _, s := range steps {
+		got, err := Allocate(n, s.container, s.requested)
+		if err != nil {
+			t.Fatalf("For %s got error: %s", s.container, err)
+		}
+		if got != s.want {
+			t.Errorf("For %s expecting %s, got %s", s.container, s.want, got)
+		}
+	}
+
+	var invalid = map[string]string{
+		"full":      "",
+		"taken":     "10.1.0.2",
+		"gateway":   "10.1.0.1",
+		"broadcast": "10.1.0.7",
+		"outside":   "10.2.0.2",
+		"prefix":    "10.1.0.2/24",
+	}
+	for name, requested := range invalid {
+		if got, err := Allocate(n, name, requested); err == nil {
+			t.Errorf("For %s expecting error, got %s", name, got)
+		}
+	}
+
+	if err := Release(n, "a"); err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	if got, err := Allocate(n, "f", ""); err != nil || got != "10.1.0.2/29" {
+		t.Errorf("Released address not reused, got %s %v", got, err)
+	}
+
+	// address of other container is left alone
+	for _, err := range []error{ReleaseAddress(n, "f", "10.1.0.3/29"), ReleaseAddress(n, "e", "10.1.0.4")} {
+		if err != nil {
+			t.Fatal("Got error: ", err)
+		}
+	}
+	leases, err := Leases(n)
+	if err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	if leases["10.1.0.3"] != "d" || leases["10.1.0.4"] != "" {
+		t.Errorf("Expecting 10.1.0.3 kept and 10.1.0.4 released, got %v", leases)
+	}
+}
+
+func TestAllocate6(t *testing.T) {
+	dir, err := ioutil.TempDir("", "networks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	SetConfigPath(dir)
+	n := &Network{Name: "test", Subnet: "10.1.0.0/29", Gateway: "10.1.0.1", Subnet6: "fd00:1::/125", Gateway6: "fd00:1::1"}
+
+	var steps = []struct {
+		container, requested, want string
+	}{
+		{"a", "", "fd00:1::2/125"},
+		{"b", "fd00:1::7", "fd00:1::7/125"},
+		{"a", "", "fd00:1::2/125"},
+		{"c", "", "fd00:1::3/125"},
+	}
+	for _, s := range steps {
+		got, err := Allocate6(n, s.container, s.requested)
+		if err != nil {
+			t.Fatalf("For %s got error: %s", s.container, err)
+		}
+		if got != s.want {
+			t.Errorf("For %s expecting %s, got %s", s.container, s.want, got)
+		}
+	}
+	// IPv4 lease is separate from IPv6 one
+	if got, err := Allocate(n, "a", ""); err != nil || got != "10.1.0.2/29" {
+		t.Errorf("For a expecting 10.1.0.2/29, got %s %v", got, err)
+	}
+
+	var invalid = map[string]string{
+		"taken":   "fd00:1::2",
+		"gateway": "fd00:1::1",
+		"anycast": "fd00:1::",
+		"outside": "fd00:2::2",
+		"ipv4":    "10.1.0.3",
+	}
+	for name, requested := range invalid {
+		if got, err := Allocate6(n, name, requested); err == nil {
+			t.Errorf("For %s expecting error, got %s", name, got)
+		}
+	}
+
+	if err := Release(n, "a"); err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	leases, err := Leases(n)
+	if err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	for ip, c := range leases {
+		if c == "a" {
+			t.Errorf("Address %s of released container still leased", ip)
+		}
+	}
+	if _, err := Allocate6(&Network{Name: "v4", Subnet: "10.2.0.0/24"}, "a", ""); err == nil {
+		t.Error("Expecting error for network without IPv6 subnet")
+	}
+}
+
+func TestAllocateConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "networks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	SetConfigPath(dir)
+	n := &Network{Name: "test", Subnet: "10.1.0.0/24", Gateway: "10.1.0.1"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := Allocate(n, fmt.Sprintf("c%d", i), ""); err != nil {
+				t.Error("Got error: ", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	leases, err := Leases(n)
+	if err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	if len(leases) != 20 {
+		t.Errorf("Expecting 20 leases, got %d", len(leases))
+	}
+}
@@ -0,0 +1,231 @@
+package network
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/google/nftables/userdata"
+	"golang.org/x/sys/unix"
+)
+
+/*
+ Outbound traffic of bridge networks is masqueraded behind address of the host.
+ All rules live in our own nftables table, one postrouting rule per network:
+	table ip cntcli {
+		chain postrouting {
+			type nat hook postrouting priority srcnat;
+			ip saddr 192.168.99.0/24 oifname != "cnt0" masquerade comment "bridge"
+		}
+	}
+ Comment holds network name so rule can be found again on removal. Dual-stack network with NAT6
+ gets the same rule in ip6 table of the same name.
+ Same as `nft -f` rules they are gone after reboot, EnableNAT is called on every container start.
+*/
+
+const (
+	natTable = "cntcli"
+	natChain = "postrouting"
+)
+
+// header offsets of source address
+const (
+	offsetSaddr  = 12
+	offsetSaddr6 = 8
+)
+
+func natObjects(family nftables.TableFamily) (*nftables.Table, *nftables.Chain) {
+	table := &nftables.Table{Name: natTable, Family: family}
+	chain := &nftables.Chain{
+		Name:     natChain,
+		Table:    table,
+		Type:     nftables.ChainTypeNAT,
+		Hooknum:  nftables.ChainHookPostrouting,
+		Priority: nftables.ChainPriorityNATSource,
+	}
+	return table, chain
+}
+
+// EnableForwarding turns on routing of IPv4 packets between interfaces
+func EnableForwarding() error {
+	return ioutil.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1"), 0644)
+}
+
+/*
+ EnableForwarding6 turns on routing of IPv6 packets between interfaces. Router ignores
+ router advertisements with default accept_ra=1 and host configured by SLAAC would lose
+ its default route, so interfaces (and default for new ones) get accept_ra=2 first.
+ Forwarding turned on already, by us or admin, is left alone.
+*/
+func EnableForwarding6() error {
+	const conf = "/proc/sys/net/ipv6/conf"
+	forwarding, err := ioutil.ReadFile(filepath.Join(conf, "all", "forwarding"))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(forwarding)) == "1" {
+		return nil
+	}
+	interfaces, err := ioutil.ReadDir(conf)
+	if err != nil {
+		return err
+	}
+	for _, i := range interfaces {
+		// all/accept_ra isn't used by kernel
+		if i.Name() == "all" {
+			continue
+		}
+		acceptRA := filepath.Join(conf, i.Name(), "accept_ra")
+		value, err := ioutil.ReadFile(acceptRA)
+		if err != nil || strings.TrimSpace(string(value)) != "1" {
+			continue
+		}
+		if err := ioutil.WriteFile(acceptRA, []byte("2"), 0644); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(filepath.Join(conf, "all", "forwarding"), []byte("1"), 0644)
+}
+
+// EnableNAT enables forwarding and adds masquerade rules of network, existing rules are kept.
+// IPv6 of dual-stack network is forwarded always, masqueraded only with NAT6
+func EnableNAT(n *Network) error {
+	if err := EnableForwarding(); err != nil {
+		return err
+	}
+	if err := addMasquerade(n, n.Subnet, nftables.TableFamilyIPv4); err != nil {
+		return err
+	}
+	if n.Subnet6 == "" {
+		return nil
+	}
+	if err := EnableForwarding6(); err != nil {
+		return err
+	}
+	if !n.NAT6 {
+		return nil
+	}
+	return addMasquerade(n, n.Subnet6, nftables.TableFamilyIPv6)
+}
+
+func addMasquerade(n *Network, cidr string, family nftables.TableFamily) error {
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	conn, err := nftables.New()
+	if err != nil {
+		return err
+	}
+	table, chain := natObjects(family)
+	// adding existing table or chain is not an error
+	conn.AddTable(table)
+	conn.AddChain(chain)
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+
+	rules, err := commentRules(conn, table, chain, n.Name)
+	if err != nil || len(rules) > 0 {
+		return err
+	}
+	offset, ip := uint32(offsetSaddr), subnet.IP.To4()
+	if family == nftables.TableFamilyIPv6 {
+		offset, ip = offsetSaddr6, subnet.IP.To16()
+	}
+	size := uint32(len(ip))
+	conn.AddRule(&nftables.Rule{
+		Table: table,
+		Chain: chain,
+		Exprs: []expr.Any{
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: offset, Len: size},
+			&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: size, Mask: subnet.Mask, Xor: make([]byte, size)},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ip},
+			&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: ifname(n.Bridge)},
+			&expr.Masq{},
+		},
+		UserData: userdata.AppendString(nil, userdata.TypeComment, n.Name),
+	})
+	return conn.Flush()
+}
+
+// DisableNAT deletes masquerade rules of network, table is removed with last rule in it
+func DisableNAT(n *Network) error {
+	if err := deleteMasquerade(n, nftables.TableFamilyIPv4); err != nil {
+		return err
+	}
+	return deleteMasquerade(n, nftables.TableFamilyIPv6)
+}
+
+func deleteMasquerade(n *Network, family nftables.TableFamily) error {
+	conn, err := nftables.New()
+	if err != nil {
+		return err
+	}
+	table, chain := natObjects(family)
+	if _, err := conn.ListTableOfFamily(natTable, family); err != nil {
+		// nothing was ever added
+		return nil
+	}
+	rules, err := commentRules(conn, table, chain, n.Name)
+	if err != nil {
+		return err
+	}
+	for _, r := range rules {
+		if err := conn.DelRule(r); err != nil {
+			return err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	return deleteTableIfEmpty(conn, table)
+}
+
+func deleteTableIfEmpty(conn *nftables.Conn, table *nftables.Table) error {
+	chains, err := conn.ListChainsOfTableFamily(table.Family)
+	if err != nil {
+		return err
+	}
+	for _, c := range chains {
+		if c.Table.Name != table.Name {
+			continue
+		}
+		if rules, err := conn.GetRules(table, c); err != nil || len(rules) > 0 {
+			return err
+		}
+	}
+	conn.DelTable(table)
+	return conn.Flush()
+}
+
+// rules in chain recognized by comment, it holds name of network or container
+func commentRules(conn *nftables.Conn, table *nftables.Table, chain *nftables.Chain, name string) ([]*nftables.Rule, error) {
+	rules, err := conn.GetRules(table, chain)
+	if err != nil {
+		// chain doesn't exist
+		if errors.Is(err, unix.ENOENT) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var result []*nftables.Rule
+	for _, r := range rules {
+		if comment, ok := userdata.GetString(r.UserData, userdata.TypeComment); ok && comment == name {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+// interface names are compared as zero padded IFNAMSIZ bytes
+func ifname(name string) []byte {
+	b := make([]byte, unix.IFNAMSIZ)
+	copy(b, name)
+	return b
+}
@@ -0,0 +1,400 @@
+package network
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// runs test in new network namespace, thread stays in it and is thrown away afterwards
+func inNetNs(t *testing.T, test func()) {
+	if os.Geteuid() != 0 {
+		t.Skip("network namespace needs root")
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runtime.LockOSThread()
+		if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+			t.Error("Got error: ", err)
+			return
+		}
+		test()
+	}()
+	<-done
+}
+
+func hasAddress(link netlink.Link, cidr string) bool {
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if a.IPNet.String() == cidr {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFinalConfigDualStack(t *testing.T) {
+	inNetNs(t, func() {
+		// same kind of link container gets, peer stays on this side
+		veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "test0"}, PeerName: "test1"}
+		if err := netlink.LinkAdd(veth); err != nil {
+			t.Error("Got error: ", err)
+			return
+		}
+		peer, err := netlink.LinkByName("test1")
+		if err == nil {
+			err = netlink.LinkSetUp(peer)
+		}
+		if err != nil {
+			t.Error("Got error: ", err)
+			return
+		}
+		err = FinalConfig("test0", []string{"10.1.0.2/24", "fd00:1::2/64"}, []string{"10.1.0.1", "fd00:1::1"}, 1400)
+		if err != nil {
+			t.Error("Got error: ", err)
+			return
+		}
+		link, err := netlink.LinkByName(ContainerInterface)
+		if err != nil {
+			t.Error("Got error: ", err)
+			return
+		}
+		for _, a := range []string{"10.1.0.2/24", "fd00:1::2/64"} {
+			if !hasAddress(link, a) {
+				t.Errorf("Address %s missing", a)
+			}
+		}
+		if mac := link.Attrs().HardwareAddr.String(); mac != "02:42:0a:01:00:02" {
+			t.Errorf("Expecting MAC 02:42:0a:01:00:02, got %s", mac)
+		}
+		if link.Attrs().MTU != 1400 {
+			t.Errorf("Expecting MTU 1400, got %d", link.Attrs().MTU)
+		}
+		var cases = map[int]string{
+			netlink.FAMILY_V4: "10.1.0.1",
+			netlink.FAMILY_V6: "fd00:1::1",
+		}
+		for family, gw := range cases {
+			routes, err := netlink.RouteList(link, family)
+			if err != nil {
+				t.Error("Got error: ", err)
+				continue
+			}
+			found := false
+			for _, r := range routes {
+				if r.Dst == nil || r.Dst.IP.IsUnspecified() {
+					found = found || r.Gw.Equal(net.ParseIP(gw))
+				}
+			}
+			if !found {
+				t.Errorf("For %s default route missing in %v", gw, routes)
+			}
+		}
+	})
+}
+
+func TestFinalConfigWithoutGateway(t *testing.T) {
+	inNetNs(t, func() {
+		veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "test0"}, PeerName: "test1"}
+		if err := netlink.LinkAdd(veth); err != nil {
+			t.Error("Got error: ", err)
+			return
+		}
+		// ipvlan l3 container routes everything through its interface
+		if err := FinalConfig("test0", []string{"10.1.0.2/24", "fd00:1::2/64"}, []string{"", ""}, 0); err != nil {
+			t.Error("Got error: ", err)
+			return
+		}
+		link, err := netlink.LinkByName(ContainerInterface)
+		if err != nil {
+			t.Error("Got error: ", err)
+			return
+		}
+		for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+			routes, err := netlink.RouteList(link, family)
+			if err != nil {
+				t.Error("Got error: ", err)
+				continue
+			}
+			found := false
+			for _, r := range routes {
+				if r.Dst != nil && r.Dst.IP.IsUnspecified() && r.Gw == nil {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("For family %d default route missing in %v", family, routes)
+			}
+		}
+	})
+}
+
+func TestFinalConfigDAD(t *testing.T) {
+	// second address is taken by other host on LAN
+	var cases = map[string]bool{
+		"fd00:1::2/64": true,
+		"fd00:1::3/64": false,
+	}
+	for address, free := range cases {
+		inNetNs(t, func() {
+			lan := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "lan0"}, PeerName: "lan1"}
+			if err := netlink.LinkAdd(lan); err != nil {
+				t.Error("Got error: ", err)
+				return
+			}
+			other, err := netlink.LinkByName("lan1")
+			if err != nil {
+				t.Error("Got error: ", err)
+				return
+			}
+			taken, _ := netlink.ParseAddr("fd00:1::3/64")
+			taken.Flags = unix.IFA_F_NODAD
+			for _, err := range []error{netlink.LinkSetUp(lan), netlink.LinkSetUp(other), netlink.AddrAdd(other, taken)} {
+				if err != nil {
+					t.Error("Got error: ", err)
+					return
+				}
+			}
+			macvlan := &netlink.Macvlan{
+				LinkAttrs: netlink.LinkAttrs{Name: "test0", ParentIndex: lan.Attrs().Index},
+				Mode:      netlink.MACVLAN_MODE_BRIDGE,
+			}
+			if err := netlink.LinkAdd(macvlan); err != nil {
+				t.Error("Got error: ", err)
+				return
+			}
+			err = FinalConfig("test0", []string{address}, []string{""}, 0)
+			if free && err != nil {
+				t.Errorf("For %s got error: %s", address, err)
+			}
+			if !free && err == nil {
+				t.Errorf("For %s expecting duplicate address error", address)
+			}
+		})
+	}
+}
+
+func TestSetupVlan(t *testing.T) {
+	inNetNs(t, func() {
+		lan := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "lan0"}, PeerName: "lan1"}
+		if err := netlink.LinkAdd(lan); err != nil {
+			t.Error("Got error: ", err)
+			return
+		}
+		// container is process in its own network namespace, forked from this thread
+		cmd := exec.Command("sleep", "10")
+		cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNET}
+		if err := cmd.Start(); err != nil {
+			t.Error("Got error: ", err)
+			return
+		}
+		defer cmd.Wait()
+		defer cmd.Process.Kill()
+		ns, err := netns.GetFromPid(cmd.Process.Pid)
+		if err != nil {
+			t.Error("Got error: ", err)
+			return
+		}
+		defer ns.Close()
+		handle, err := netlink.NewHandleAt(ns)
+		if err != nil {
+			t.Error("Got error: ", err)
+			return
+		}
+		defer handle.Close()
+
+		var cases = map[string]*Network{
+			"mv": {Name: "mv", Driver: DriverMacvlan, Parent: "lan0", Mode: "bridge"},
+			"iv": {Name: "iv", Driver: DriverIPvlan, Parent: "lan0", Mode: "l3", MTU: 1400},
+		}
+		for name, n := range cases {
+			if err := Setup(n, name, cmd.Process.Pid); err != nil {
+				// ipvlan module is not everywhere
+				if n.Driver == DriverIPvlan {
+					t.Logf("For %s got error: %s", name, err)
+					continue
+				}
+				t.Errorf("For %s got error: %s", name, err)
+				continue
+			}
+			_, peer := VethNames(name)
+			if _, err := netlink.LinkByName(peer); err == nil {
+				t.Errorf("For %s link %s left on host", name, peer)
+			}
+			link, err := handle.LinkByName(peer)
+			if err != nil {
+				t.Errorf("For %s got error: %s", name, err)
+				continue
+			}
+			if link.Type() != n.Driver {
+				t.Errorf("For %s expecting %s link, got %s", name, n.Driver, link.Type())
+			}
+			if n.MTU > 0 && link.Attrs().MTU != n.MTU {
+				t.Errorf("For %s expecting MTU %d, got %d", name, n.MTU, link.Attrs().MTU)
+			}
+		}
+	})
+}
+
+func TestEnsureBridgeDualStack(t *testing.T) {
+	inNetNs(t, func() {
+		n := &Network{Name: "test", Bridge: "br-test", Subnet: "10.1.0.0/24", Gateway: "10.1.0.1",
+			Subnet6: "fd00:1::/64", Gateway6: "fd00:1::1", NAT6: true}
+		// second call finds everything in place
+		for i := 0; i < 2; i++ {
+			bridge, err := EnsureBridge(n)
+			if err != nil {
+				t.Error("Got error: ", err)
+				return
+			}
+			for _, a := range []string{"10.1.0.1/24", "fd00:1::1/64"} {
+				if !hasAddress(bridge, a) {
+					t.Errorf("Address %s missing", a)
+				}
+			}
+		}
+	})
+}
+
+func TestNATDualStack(t *testing.T) {
+	inNetNs(t, func() {
+		n := &Network{Name: "test", Bridge: "br-test", Subnet: "10.1.0.0/24", Gateway: "10.1.0.1",
+			Subnet6: "fd00:1::/64", Gateway6: "fd00:1::1", NAT6: true}
+		conn, err := nftables.New()
+		if err != nil {
+			t.Error("Got error: ", err)
+			return
+		}
+		// rules are added once however many containers start
+		for i := 0; i < 2; i++ {
+			if err := EnableNAT(n); err != nil {
+				t.Error("Got error: ", err)
+				return
+			}
+		}
+		for _, family := range []nftables.TableFamily{nftables.TableFamilyIPv4, nftables.TableFamilyIPv6} {
+			table, chain := natObjects(family)
+			rules, err := commentRules(conn, table, chain, n.Name)
+			if err != nil || len(rules) != 1 {
+				t.Errorf("For family %d expecting 1 rule, got %d %v", family, len(rules), err)
+			}
+		}
+
+		if err := DisableNAT(n); err != nil {
+			t.Error("Got error: ", err)
+			return
+		}
+		for _, family := range []nftables.TableFamily{nftables.TableFamilyIPv4, nftables.TableFamilyIPv6} {
+			if _, err := conn.ListTableOfFamily(natTable, family); err == nil {
+				t.Errorf("For family %d table left behind", family)
+			}
+		}
+	})
+}
+
+func TestEnableForwarding6KeepsRouterAdvertisements(t *testing.T) {
+	inNetNs(t, func() {
+		const conf = "/proc/sys/net/ipv6/conf"
+		for _, name := range []string{"lo", "default"} {
+			if err := ioutil.WriteFile(filepath.Join(conf, name, "accept_ra"), []byte("1"), 0644); err != nil {
+				t.Error("Got error: ", err)
+				return
+			}
+		}
+		if err := EnableForwarding6(); err != nil {
+			t.Error("Got error: ", err)
+			return
+		}
+		var cases = map[string]string{
+			"all/forwarding":    "1",
+			"lo/accept_ra":      "2",
+			"default/accept_ra": "2",
+		}
+		for name, want := range cases {
+			got, err := ioutil.ReadFile(filepath.Join(conf, name))
+			if err != nil {
+				t.Errorf("For %s got error: %s", name, err)
+				continue
+			}
+			if strings.TrimSpace(string(got)) != want {
+				t.Errorf("For %s expecting %s, got %s", name, want, got)
+			}
+		}
+	})
+}
+
+func TestPublishPorts(t *testing.T) {
+	inNetNs(t, func() {
+		conn, err := nftables.New()
+		if err != nil {
+			t.Error("Got error: ", err)
+			return
+		}
+		ports := []PortMapping{{8080, 80, "tcp"}, {5353, 53, "udp"}}
+		for name, ip := range map[string]string{"web": "10.1.0.2/24", "db": "10.1.0.3"} {
+			if err := PublishPorts(name, ip, ports); err != nil {
+				t.Error("Got error: ", err)
+				return
+			}
+		}
+		table, postrouting := natObjects(nftables.TableFamilyIPv4)
+		chains := append(dnatChains(table), postrouting)
+		// DNAT rule per port in prerouting and output, single hairpin masquerade in postrouting
+		count := func(name string) map[string]int {
+			counts := make(map[string]int)
+			for _, chain := range chains {
+				comment := name
+				if chain == postrouting {
+					comment = hairpinComment(name)
+				}
+				rules, err := commentRules(conn, table, chain, comment)
+				if err != nil {
+					t.Error("Got error: ", err)
+				}
+				counts[chain.Name] = len(rules)
+			}
+			return counts
+		}
+		want := map[string]int{preroutingChain: 2, outputChain: 2, natChain: 1}
+		for _, name := range []string{"web", "db"} {
+			if got := count(name); !reflect.DeepEqual(got, want) {
+				t.Errorf("For %s expecting %v, got %v", name, want, got)
+			}
+		}
+
+		if err := UnpublishPorts("web"); err != nil {
+			t.Error("Got error: ", err)
+			return
+		}
+		empty := map[string]int{preroutingChain: 0, outputChain: 0, natChain: 0}
+		if got := count("web"); !reflect.DeepEqual(got, empty) {
+			t.Errorf("For web expecting %v, got %v", empty, got)
+		}
+		if got := count("db"); !reflect.DeepEqual(got, want) {
+			t.Errorf("For db expecting %v, got %v", want, got)
+		}
+		if err := UnpublishPorts("db"); err != nil {
+			t.Error("Got error: ", err)
+			return
+		}
+		if _, err := conn.ListTableOfFamily(natTable, nftables.TableFamilyIPv4); err == nil {
+			t.Error("Table left behind")
+		}
+	})
+}
@@ -1,39 +1,87 @@
 package network
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
-//Setup is responsible for adding veth and connecting it to bridge
-func Setup(pid int) error {
+// how long FinalConfig waits for duplicate address detection of IPv6 addresses
+const dadTimeout = 5 * time.Second
+
+// ContainerInterface is name of veth end inside container
+const ContainerInterface = "eth0"
+
+// VethNames returns names of host and container end of veth pair for container.
+// Both are derived from container name so every container gets own pair
+// and host end can be found again on cleanup. Kernel limits names to 15 characters.
+func VethNames(containerName string) (host, peer string) {
+	sum := sha256.Sum256([]byte(containerName))
+	id := hex.EncodeToString(sum[:])[:8]
+	return "veth" + id, "ceth" + id
+}
+
+//Setup is responsible for adding veth and connecting it to bridge of network,
+//macvlan and ipvlan networks get their link straight in container namespace
+func Setup(n *Network, containerName string, pid int) error {
+	if !n.IsBridge() {
+		return setupVlan(n, containerName, pid)
+	}
+	hostName, peerName := VethNames(containerName)
+
+	// get bridge reference, it is created on first use
+	bridge, err := EnsureBridge(n)
+	if err != nil {
+		return err
+	}
+	// rules are lost on reboot like the bridge, so they are checked on every start
+	if err := EnableNAT(n); err != nil {
+		return fmt.Errorf("setting up NAT: %s", err)
+	}
 
-	// get bridge reference
-	la := netlink.NewLinkAttrs()
-	la.Name = "tst"
-	mybridge := &netlink.Bridge{LinkAttrs: la}
+	// leftover of container that was killed before it could clean up
+	if err := Teardown(containerName); err != nil {
+		return err
+	}
 
 	//create veth config
 	veth := &netlink.Veth{
-		PeerName:  "cnt-p2",
-		LinkAttrs: netlink.LinkAttrs{Name: "cnt-p1"},
+		PeerName:  peerName,
+		LinkAttrs: netlink.LinkAttrs{Name: hostName, MTU: n.LinkMTU()},
 	}
 
 	//add veth pair and get reference to new iterfaces
-	err := netlink.LinkAdd(veth)
+	err = netlink.LinkAdd(veth)
 	if err != nil {
 		return err
 	}
-	p1, err := netlink.LinkByName("cnt-p1")
+	if err := attach(hostName, peerName, bridge, pid); err != nil {
+		Teardown(containerName)
+		return err
+	}
+	return nil
+}
+
+func attach(hostName, peerName string, bridge *netlink.Bridge, pid int) error {
+	p1, err := netlink.LinkByName(hostName)
 	if err != nil {
 		return err
 	}
-	p2, err := netlink.LinkByName("cnt-p2")
+	p2, err := netlink.LinkByName(peerName)
 	if err != nil {
 		return err
 	}
 
 	// add one of interfaces to bridge
-	netlink.LinkSetMaster(p1, mybridge)
+	if err := netlink.LinkSetMaster(p1, bridge); err != nil {
+		return fmt.Errorf("connecting %s to %s: %s", hostName, bridge.Name, err)
+	}
 
 	//set first one up
 	err = netlink.LinkSetUp(p1)
@@ -42,33 +90,151 @@ func Setup(pid int) error {
 	}
 
 	//move 2nd interface to our newly created namespace
-	err = netlink.LinkSetNsPid(p2, pid)
+	return netlink.LinkSetNsPid(p2, pid)
+}
+
+// Teardown deletes host end of container veth, its peer goes away with it.
+// Missing interface is not an error, kernel removes pair when container network namespace dies
+func Teardown(containerName string) error {
+	hostName, _ := VethNames(containerName)
+	link, err := netlink.LinkByName(hostName)
 	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
 		return err
 	}
+	return netlink.LinkDel(link)
+}
 
-	return nil
+// MACAddress returns locally administered address derived from IPv4 address of container,
+// same scheme docker uses, so container keeps its MAC as long as it keeps its address
+func MACAddress(ip net.IP) net.HardwareAddr {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil
+	}
+	return net.HardwareAddr{0x02, 0x42, ip4[0], ip4[1], ip4[2], ip4[3]}
 }
 
-//FinalConfig used to set interface after passing it to new ns
-func FinalConfig() error {
-	// get link reference
-	p2, err := netlink.LinkByName("cnt-p2")
+// LoopbackUp brings up lo of current network namespace, new namespace has it down
+func LoopbackUp() error {
+	lo, err := netlink.LinkByName("lo")
 	if err != nil {
 		return err
 	}
-	//set 2nd up (moving to ns clears interface settings)
-	err = netlink.LinkSetUp(p2)
+	return netlink.LinkSetUp(lo)
+}
+
+//FinalConfig used to set interface after passing it to new ns, addresses are in CIDR notation,
+//dual-stack container has one of each family. Interface gets renamed to eth0, name on host side
+//has to be unique but inside it doesn't. Default route of each address goes through gateway
+//at the same index, empty one routes straight through interface. mtu 0 keeps one
+//interface was created with. IPv6 addresses on macvlan and ipvlan wait for duplicate
+//address detection, bridge ones skip it
+func FinalConfig(name string, addresses, gateways []string, mtu int) error {
+	// get link reference
+	p2, err := netlink.LinkByName(name)
 	if err != nil {
 		return err
 	}
-	addr, err := netlink.ParseAddr("192.168.99.2/24")
-	if err != nil {
+	// veth is our end of bridge network, macvlan and ipvlan put container on real LAN
+	_, veth := p2.(*netlink.Veth)
+	var addrs []*netlink.Addr
+	var mac net.HardwareAddr
+	for _, a := range addresses {
+		addr, err := netlink.ParseAddr(a)
+		if err != nil {
+			return err
+		}
+		if addr.IP.To4() == nil {
+			// on bridge only IPAM hands out addresses, LAN can have anybody using ours
+			if veth {
+				addr.Flags = unix.IFA_F_NODAD
+			}
+		} else if mac == nil {
+			mac = MACAddress(addr.IP)
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(gateways) != len(addrs) {
+		return errors.New("every address needs gateway")
+	}
+	// rename, MAC and MTU can be changed only while link is down, moving to ns put it down
+	if err := netlink.LinkSetName(p2, ContainerInterface); err != nil {
 		return err
 	}
-	err = netlink.AddrAdd(p2, addr)
+	// ipvlan shares MAC of its parent
+	if _, ipvlan := p2.(*netlink.IPVlan); mac != nil && !ipvlan {
+		if err := netlink.LinkSetHardwareAddr(p2, mac); err != nil {
+			return fmt.Errorf("setting MAC address: %s", err)
+		}
+	}
+	if mtu > 0 {
+		if err := netlink.LinkSetMTU(p2, mtu); err != nil {
+			return fmt.Errorf("setting MTU: %s", err)
+		}
+	}
+	//set 2nd up (moving to ns clears interface settings)
+	err = netlink.LinkSetUp(p2)
 	if err != nil {
 		return err
 	}
+	for _, addr := range addrs {
+		if err := netlink.AddrAdd(p2, addr); err != nil {
+			return fmt.Errorf("adding address %s: %s", addr.IPNet, err)
+		}
+	}
+	if !veth {
+		if err := waitDAD(p2); err != nil {
+			return err
+		}
+	}
+	for i, gateway := range gateways {
+		route := &netlink.Route{LinkIndex: p2.Attrs().Index}
+		if gateway == "" {
+			bits := 128
+			if addrs[i].IP.To4() != nil {
+				bits = 32
+			}
+			route.Dst = &net.IPNet{IP: make(net.IP, bits/8), Mask: net.CIDRMask(0, bits)}
+			route.Scope = netlink.SCOPE_LINK
+		} else if route.Gw = net.ParseIP(gateway); route.Gw == nil {
+			return fmt.Errorf("invalid gateway %q", gateway)
+		}
+		// link scope route to subnet comes with address, gateway is reachable through it
+		if err := netlink.RouteAdd(route); err != nil {
+			return fmt.Errorf("adding default route of %s: %s", addrs[i].IPNet, err)
+		}
+	}
 	return nil
 }
+
+// waits until duplicate address detection of global IPv6 addresses finishes,
+// address stays unusable when another host on LAN has it
+func waitDAD(link netlink.Link) error {
+	deadline := time.Now().Add(dadTimeout)
+	for {
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V6)
+		if err != nil {
+			return err
+		}
+		tentative := false
+		for _, a := range addrs {
+			if a.Scope != unix.RT_SCOPE_UNIVERSE {
+				continue
+			}
+			if a.Flags&unix.IFA_F_DADFAILED != 0 {
+				return fmt.Errorf("address %s is already used on network", a.IPNet)
+			}
+			tentative = tentative || a.Flags&unix.IFA_F_TENTATIVE != 0
+		}
+		if !tentative {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("duplicate address detection on %s didn't finish in %s", link.Attrs().Name, dadTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
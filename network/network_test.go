@@ -0,0 +1,24 @@
+package network
+
+import (
+	"testing"
+)
+
+func TestVethNames(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, name := range []string{"web", "db", "a-very-long-container-name-that-does-not-fit"} {
+		host, peer := VethNames(name)
+		for _, n := range []string{host, peer} {
+			if len(n) > 15 {
+				t.Errorf("For %s interface name %s is too long", name, n)
+			}
+			if seen[n] {
+				t.Errorf("For %s interface name %s is not unique", name, n)
+			}
+			seen[n] = true
+		}
+		if h, p := VethNames(name); h != host || p != peer {
+			t.Errorf("For %s names are not stable", name)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/google/nftables/userdata"
+	"golang.org/x/sys/unix"
+)
+
+/*
+ Published ports are DNAT rules in our table, same rule goes to prerouting chain for traffic
+ coming from outside and to output chain for traffic from host itself:
+	chain prerouting {
+		type nat hook prerouting priority dstnat;
+		fib daddr type local ip daddr != 127.0.0.0/8 meta l4proto tcp th dport 8080 dnat to 192.168.99.2:80 comment "web"
+	}
+ Comment holds container name, all rules of container are removed together when it stops.
+ Connections to 127.0.0.1 can't be DNAT-ed to bridge without route_localnet,
+ StartProxy forwards them in userspace instead.
+ Container connecting to published port of its neighbour on the same bridge would get reply
+ straight from neighbour's address, such hairpin traffic is masqueraded behind bridge address:
+	chain postrouting {
+		ct status dnat ip saddr 192.168.99.0/24 ip daddr 192.168.99.2 masquerade comment "hairpin:web"
+	}
+ Masquerade rules of networks share the chain, colon can't be in network name.
+*/
+
+const (
+	preroutingChain = "prerouting"
+	outputChain     = "output"
+)
+
+// offsets of destination address in IPv4 header and destination port in TCP and UDP header
+const (
+	offsetDaddr = 16
+	offsetDport = 2
+)
+
+// IPS_DST_NAT bit of conntrack status
+const ctStatusDNAT = 1 << 5
+
+// PortMapping publishes container port on host
+type PortMapping struct {
+	HostPort      int
+	ContainerPort int
+	// tcp or udp
+	Protocol string
+}
+
+func (m PortMapping) String() string {
+	return fmt.Sprintf("%d:%d/%s", m.HostPort, m.ContainerPort, m.Protocol)
+}
+
+// ParsePortMapping parses hostPort:containerPort[/tcp|udp], protocol defaults to tcp
+func ParsePortMapping(spec string) (PortMapping, error) {
+	m := PortMapping{Protocol: "tcp"}
+	ports := spec
+	if i := strings.LastIndex(spec, "/"); i >= 0 {
+		ports, m.Protocol = spec[:i], spec[i+1:]
+		if m.Protocol != "tcp" && m.Protocol != "udp" {
+			return m, fmt.Errorf("invalid protocol %q in %q, expecting tcp or udp", m.Protocol, spec)
+		}
+	}
+	parts := strings.Split(ports, ":")
+	if len(parts) != 2 {
+		return m, fmt.Errorf("invalid port mapping %q, expecting hostPort:containerPort[/tcp|udp]", spec)
+	}
+	var err error
+	if m.HostPort, err = parsePort(parts[0]); err != nil {
+		return m, err
+	}
+	if m.ContainerPort, err = parsePort(parts[1]); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+func dnatChains(table *nftables.Table) []*nftables.Chain {
+	return []*nftables.Chain{
+		{
+			Name:     preroutingChain,
+			Table:    table,
+			Type:     nftables.ChainTypeNAT,
+			Hooknum:  nftables.ChainHookPrerouting,
+			Priority: nftables.ChainPriorityNATDest,
+		},
+		{
+			Name:     outputChain,
+			Table:    table,
+			Type:     nftables.ChainTypeNAT,
+			Hooknum:  nftables.ChainHookOutput,
+			Priority: nftables.ChainPriorityNATDest,
+		},
+	}
+}
+
+// PublishPorts adds DNAT rules of container. Prefix of containerIP tells which neighbours
+// get hairpin masquerade, without it only container connecting to its own port does
+func PublishPorts(containerName, containerIP string, ports []PortMapping) error {
+	if len(ports) == 0 {
+		return nil
+	}
+	ip, subnet, err := net.ParseCIDR(containerIP)
+	if err != nil {
+		if ip = net.ParseIP(containerIP); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("invalid container address %q", containerIP)
+		}
+		subnet = &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}
+	}
+	if err := EnableForwarding(); err != nil {
+		return err
+	}
+	conn, err := nftables.New()
+	if err != nil {
+		return err
+	}
+	table, postrouting := natObjects(nftables.TableFamilyIPv4)
+	conn.AddTable(table)
+	for _, chain := range dnatChains(table) {
+		conn.AddChain(chain)
+		for _, m := range ports {
+			conn.AddRule(&nftables.Rule{
+				Table:    table,
+				Chain:    chain,
+				Exprs:    dnatExprs(m, ip.To4()),
+				UserData: userdata.AppendString(nil, userdata.TypeComment, containerName),
+			})
+		}
+	}
+	conn.AddChain(postrouting)
+	conn.AddRule(&nftables.Rule{
+		Table:    table,
+		Chain:    postrouting,
+		Exprs:    hairpinExprs(ip.To4(), subnet),
+		UserData: userdata.AppendString(nil, userdata.TypeComment, hairpinComment(containerName)),
+	})
+	return conn.Flush()
+}
+
+func hairpinComment(containerName string) string {
+	return "hairpin:" + containerName
+}
+
+func hairpinExprs(ip net.IP, subnet *net.IPNet) []expr.Any {
+	return []expr.Any{
+		&expr.Ct{Register: 1, Key: expr.CtKeySTATUS},
+		&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 4,
+			Mask: binaryutil.NativeEndian.PutUint32(ctStatusDNAT), Xor: make([]byte, 4)},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: make([]byte, 4)},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: offsetSaddr, Len: 4},
+		&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 4, Mask: subnet.Mask, Xor: make([]byte, 4)},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: subnet.IP.To4()},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: offsetDaddr, Len: 4},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ip},
+		&expr.Masq{},
+	}
+}
+
+func dnatExprs(m PortMapping, ip net.IP) []expr.Any {
+	proto := byte(unix.IPPROTO_TCP)
+	if m.Protocol == "udp" {
+		proto = unix.IPPROTO_UDP
+	}
+	return []expr.Any{
+		// only packets for addresses of this host
+		&expr.Fib{Register: 1, FlagDADDR: true, ResultADDRTYPE: true},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(unix.RTN_LOCAL)},
+		// loopback is left to proxy
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: offsetDaddr, Len: 4},
+		&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 4, Mask: []byte{255, 0, 0, 0}, Xor: make([]byte, 4)},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{127, 0, 0, 0}},
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{proto}},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: offsetDport, Len: 2},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.BigEndian.PutUint16(uint16(m.HostPort))},
+		&expr.Immediate{Register: 1, Data: ip},
+		&expr.Immediate{Register: 2, Data: binaryutil.BigEndian.PutUint16(uint16(m.ContainerPort))},
+		&expr.NAT{
+			Type:        expr.NATTypeDestNAT,
+			Family:      unix.NFPROTO_IPV4,
+			RegAddrMin:  1,
+			RegProtoMin: 2,
+			Specified:   true,
+		},
+	}
+}
+
+// UnpublishPorts deletes DNAT and hairpin rules of container, container without them is not an error
+func UnpublishPorts(containerName string) error {
+	conn, err := nftables.New()
+	if err != nil {
+		return err
+	}
+	table, postrouting := natObjects(nftables.TableFamilyIPv4)
+	if _, err := conn.ListTableOfFamily(natTable, nftables.TableFamilyIPv4); err != nil {
+		return nil
+	}
+	for _, chain := range append(dnatChains(table), postrouting) {
+		comment := containerName
+		if chain == postrouting {
+			comment = hairpinComment(containerName)
+		}
+		rules, err := commentRules(conn, table, chain, comment)
+		if err != nil {
+			return err
+		}
+		for _, r := range rules {
+			if err := conn.DelRule(r); err != nil {
+				return err
+			}
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	return deleteTableIfEmpty(conn, table)
+}
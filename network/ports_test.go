@@ -0,0 +1,30 @@
+package network
+
+import (
+	"testing"
+)
+
+func TestParsePortMapping(t *testing.T) {
+	var valid = map[string]PortMapping{
+		"8080:80":     {HostPort: 8080, ContainerPort: 80, Protocol: "tcp"},
+		"8080:80/tcp": {HostPort: 8080, ContainerPort: 80, Protocol: "tcp"},
+		"5353:53/udp": {HostPort: 5353, ContainerPort: 53, Protocol: "udp"},
+		"65535:1":     {HostPort: 65535, ContainerPort: 1, Protocol: "tcp"},
+	}
+	for spec, want := range valid {
+		got, err := ParsePortMapping(spec)
+		if err != nil {
+			t.Errorf("For %s got error: %s", spec, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("For %s expecting %v, got %v", spec, want, got)
+		}
+	}
+
+	for _, spec := range []string{"80", "8080:80/sctp", "0:80", "8080:70000", "a:80", "1:2:3", "8080:80/"} {
+		if got, err := ParsePortMapping(spec); err == nil {
+			t.Errorf("For %s expecting error, got %v", spec, got)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package network
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+ Userspace proxy for published ports on 127.0.0.1, same job docker-proxy does.
+ It runs in cntcli process for as long as container does. Binding the port also tells
+ early when it is taken by another container or service on host.
+*/
+
+// UDP clients without traffic for this long are forgotten
+const udpTimeout = 90 * time.Second
+
+const udpBufferSize = 65507
+
+// StartProxy listens on 127.0.0.1:HostPort and forwards to container port, Close stops it
+func StartProxy(m PortMapping, containerIP string) (io.Closer, error) {
+	ip, _, err := net.ParseCIDR(containerIP)
+	if err != nil {
+		if ip = net.ParseIP(containerIP); ip == nil {
+			return nil, fmt.Errorf("invalid container address %q", containerIP)
+		}
+	}
+	listen := net.JoinHostPort("127.0.0.1", strconv.Itoa(m.HostPort))
+	target := net.JoinHostPort(ip.String(), strconv.Itoa(m.ContainerPort))
+	if m.Protocol == "udp" {
+		addr, err := net.ResolveUDPAddr("udp", listen)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := net.ListenUDP("udp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("publishing port %s: %s", m, err)
+		}
+		go proxyUDP(conn, target)
+		return conn, nil
+	}
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, fmt.Errorf("publishing port %s: %s", m, err)
+	}
+	go proxyTCP(l, target)
+	return l, nil
+}
+
+func proxyTCP(l net.Listener, target string) {
+	for {
+		client, err := l.Accept()
+		if err != nil {
+			// listener was closed
+			return
+		}
+		go func() {
+			defer client.Close()
+			backend, err := net.Dial("tcp", target)
+			if err != nil {
+				log.Println("Proxy can't connect to container: ", err)
+				return
+			}
+			defer backend.Close()
+			done := make(chan struct{}, 2)
+			go pipe(backend, client, done)
+			go pipe(client, backend, done)
+			// other direction can still have data in flight, half close lets it finish
+			<-done
+			<-done
+		}()
+	}
+}
+
+func pipe(dst, src net.Conn, done chan<- struct{}) {
+	io.Copy(dst, src)
+	if c, ok := dst.(*net.TCPConn); ok {
+		c.CloseWrite()
+	}
+	done <- struct{}{}
+}
+
+// every client gets its own socket to container, replies are sent back to client through listener
+func proxyUDP(conn *net.UDPConn, target string) {
+	var mu sync.Mutex
+	backends := make(map[string]*net.UDPConn)
+	buf := make([]byte, udpBufferSize)
+	for {
+		n, client, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// listener was closed
+			mu.Lock()
+			for _, b := range backends {
+				b.Close()
+			}
+			mu.Unlock()
+			return
+		}
+		key := client.String()
+		mu.Lock()
+		backend, ok := backends[key]
+		mu.Unlock()
+		if !ok {
+			raddr, err := net.ResolveUDPAddr("udp", target)
+			if err != nil {
+				log.Println("Proxy can't resolve container address: ", err)
+				continue
+			}
+			if backend, err = net.DialUDP("udp", nil, raddr); err != nil {
+				log.Println("Proxy can't connect to container: ", err)
+				continue
+			}
+			mu.Lock()
+			backends[key] = backend
+			mu.Unlock()
+			go func(backend *net.UDPConn, client *net.UDPAddr) {
+				reply := make([]byte, udpBufferSize)
+				for {
+					backend.SetReadDeadline(time.Now().Add(udpTimeout))
+					n, err := backend.Read(reply)
+					if err != nil {
+						break
+					}
+					conn.WriteToUDP(reply[:n], client)
+				}
+				mu.Lock()
+				if backends[client.String()] == backend {
+					delete(backends, client.String())
+				}
+				mu.Unlock()
+				backend.Close()
+			}(backend, client)
+		}
+		backend.Write(buf[:n])
+	}
+}
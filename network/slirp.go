@@ -0,0 +1,44 @@
+package network
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// SlirpAvailable checks if slirp4netns is installed
+func SlirpAvailable() bool {
+	_, err := exec.LookPath("slirp4netns")
+	return err == nil
+}
+
+/*
+ StartSlirp gives network namespace of process pid userspace network without any privileges.
+ slirp4netns creates tap0 inside namespace with 10.0.2.100/24, gateway 10.0.2.2 and dns 10.0.2.3,
+ traffic is NATed by it through host sockets. Returns once interface is configured,
+ returned process has to be killed when container exits.
+*/
+func StartSlirp(pid int) (*os.Process, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	// ready fd is 3 in slirp4netns process
+	cmd := exec.Command("slirp4netns", "--configure", "--mtu=65520", "--disable-host-loopback", "--ready-fd=3", strconv.Itoa(pid), "tap0")
+	cmd.ExtraFiles = []*os.File{w}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return nil, err
+	}
+	go cmd.Wait()
+
+	buf := make([]byte, 1)
+	if _, err := r.Read(buf); err != nil {
+		cmd.Process.Kill()
+		return nil, fmt.Errorf("slirp4netns failed to configure network: %s", err)
+	}
+	return cmd.Process, nil
+}
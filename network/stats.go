@@ -0,0 +1,65 @@
+package network
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// InterfaceStats holds traffic counters of single network interface
+type InterfaceStats struct {
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	RxDropped uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+	TxDropped uint64
+}
+
+// GetStats returns counters of all interfaces in network namespace of given process.
+// /proc/<pid>/net/dev shows namespace of that process so we don't need to enter it.
+func GetStats(pid int) (map[string]InterfaceStats, error) {
+	f, err := os.Open("/proc/" + strconv.Itoa(pid) + "/net/dev")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseNetDev(f)
+}
+
+/*
+ /proc/net/dev looks like this, first two lines are headers:
+ Inter-|   Receive                                                |  Transmit
+  face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+     lo:     0       0    0    0    0     0          0         0        0       0    0    0    0     0       0          0
+*/
+func parseNetDev(r io.Reader) (map[string]InterfaceStats, error) {
+	stats := make(map[string]InterfaceStats)
+	scanner := bufio.NewScanner(r)
+	for line := 0; scanner.Scan(); line++ {
+		if line < 2 {
+			continue
+		}
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields := strings.Fields(parts[1])
+		if len(fields) < 16 {
+			continue
+		}
+		var v [16]uint64
+		for i := range v {
+			v[i], _ = strconv.ParseUint(fields[i], 10, 64)
+		}
+		stats[strings.TrimSpace(parts[0])] = InterfaceStats{
+			RxBytes: v[0], RxPackets: v[1], RxErrors: v[2], RxDropped: v[3],
+			TxBytes: v[8], TxPackets: v[9], TxErrors: v[10], TxDropped: v[11],
+		}
+	}
+	return stats, scanner.Err()
+}
@@ -0,0 +1,31 @@
+package network
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNetDevParsing(t *testing.T) {
+	netDev := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:     420       6    0    0    0     0          0         0      420       6    0    0    0     0       0          0
+cnt-p2:   11714      85    1    2    0     0          0         0     1268      14    3    4    0     0       0          0
+`
+	var cases = map[string]InterfaceStats{
+		"lo":     {420, 6, 0, 0, 420, 6, 0, 0},
+		"cnt-p2": {11714, 85, 1, 2, 1268, 14, 3, 4},
+	}
+
+	resp, err := parseNetDev(strings.NewReader(netDev))
+	if err != nil {
+		t.Error("Got error: ", err)
+	}
+	if len(resp) != len(cases) {
+		t.Errorf("Expecting %d interfaces, got %d", len(cases), len(resp))
+	}
+	for name, ans := range cases {
+		if resp[name] != ans {
+			t.Errorf("For %s expecting %v, got %v", name, ans, resp[name])
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+)
+
+/*
+ Macvlan and ipvlan networks put containers straight on network of parent interface,
+ usually physical LAN. Subnet and gateway are those of LAN, IPAM hands out addresses
+ from it the same way as on bridge, so subnet should be range LAN DHCP doesn't use.
+ Container link is created on parent directly in container namespace, nothing is left
+ on host and there is no NAT. Host itself can't talk to macvlan containers through parent,
+ same as with docker. Ipvlan l3 has no neighbours, containers route everything through
+ their interface and LAN needs route to subnet through the host.
+*/
+
+// network drivers
+const (
+	DriverBridge  = "bridge"
+	DriverMacvlan = "macvlan"
+	DriverIPvlan  = "ipvlan"
+)
+
+// modes of drivers by names network create takes
+var macvlanModes = map[string]netlink.MacvlanMode{
+	"bridge":  netlink.MACVLAN_MODE_BRIDGE,
+	"private": netlink.MACVLAN_MODE_PRIVATE,
+	"vepa":    netlink.MACVLAN_MODE_VEPA,
+}
+
+var ipvlanModes = map[string]netlink.IPVlanMode{
+	"l2": netlink.IPVLAN_MODE_L2,
+	"l3": netlink.IPVLAN_MODE_L3,
+}
+
+const (
+	defaultMacvlanMode = "bridge"
+	defaultIPvlanMode  = "l2"
+)
+
+// validates driver options and applies them to new network
+func (n *Network) setDriver(opts Options) error {
+	mode := opts.Mode
+	switch opts.Driver {
+	case "", DriverBridge:
+		if opts.Parent != "" || opts.Mode != "" {
+			return errors.New("bridge network has no parent or mode")
+		}
+		return nil
+	case DriverMacvlan:
+		if mode == "" {
+			mode = defaultMacvlanMode
+		}
+		if _, ok := macvlanModes[mode]; !ok {
+			return fmt.Errorf("invalid macvlan mode %q, expecting bridge, private or vepa", mode)
+		}
+	case DriverIPvlan:
+		if mode == "" {
+			mode = defaultIPvlanMode
+		}
+		if _, ok := ipvlanModes[mode]; !ok {
+			return fmt.Errorf("invalid ipvlan mode %q, expecting l2 or l3", mode)
+		}
+	default:
+		return fmt.Errorf("unknown network driver %q, expecting bridge, macvlan or ipvlan", opts.Driver)
+	}
+	if opts.Parent == "" {
+		return fmt.Errorf("%s network needs parent interface", opts.Driver)
+	}
+	if _, err := netlink.LinkByName(opts.Parent); err != nil {
+		return fmt.Errorf("parent interface %s: %s", opts.Parent, err)
+	}
+	if opts.NAT6 {
+		return fmt.Errorf("%s network is part of network of %s, it has no NAT", opts.Driver, opts.Parent)
+	}
+	n.Driver, n.Parent, n.Mode, n.Bridge = opts.Driver, opts.Parent, mode, ""
+	if n.Driver == DriverIPvlan && n.Mode == "l3" {
+		if opts.Gateway != "" || opts.Gateway6 != "" {
+			return errors.New("ipvlan l3 network has no gateway, containers route through their interface")
+		}
+		n.Gateway, n.Gateway6 = "", ""
+	}
+	return nil
+}
+
+// creates macvlan or ipvlan link on parent in network namespace of process pid
+func setupVlan(n *Network, containerName string, pid int) error {
+	parent, err := netlink.LinkByName(n.Parent)
+	if err != nil {
+		return fmt.Errorf("parent interface %s: %s", n.Parent, err)
+	}
+	_, peerName := VethNames(containerName)
+	// created right in container namespace, name can't clash with anything on host
+	la := netlink.LinkAttrs{
+		Name:        peerName,
+		ParentIndex: parent.Attrs().Index,
+		MTU:         n.MTU,
+		Namespace:   netlink.NsPid(pid),
+	}
+	var link netlink.Link
+	if n.Driver == DriverMacvlan {
+		link = &netlink.Macvlan{LinkAttrs: la, Mode: macvlanModes[n.Mode]}
+	} else {
+		link = &netlink.IPVlan{LinkAttrs: la, Mode: ipvlanModes[n.Mode]}
+	}
+	if err := netlink.LinkAdd(link); err != nil {
+		return fmt.Errorf("creating %s link on %s: %s", n.Driver, n.Parent, err)
+	}
+	return nil
+}
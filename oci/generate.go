@@ -0,0 +1,139 @@
+package oci
+
+import (
+	"path/filepath"
+	"syscall"
+
+	"github.com/odk-/dockerinternals/capabilities"
+	"github.com/odk-/dockerinternals/container"
+	"github.com/odk-/dockerinternals/registry"
+	"github.com/odk-/dockerinternals/seccomp"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// used when image doesn't set PATH, same as docker
+const defaultPath = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+/*
+ Generate returns spec running image the same way cntcli runs it: command, environment,
+ working directory and user come from image config, namespaces, capabilities, seccomp
+ and masked paths are our defaults. User names are resolved against files in rootfs
+ as spec allows only numeric ids. rootPath is written to spec as is, bundles usually
+ have it relative. User namespace is created only when mappings are given.
+*/
+func Generate(image *registry.ContainerConfig, rootfs, rootPath, hostname string, uids, gids []syscall.SysProcIDMap) (*specs.Spec, error) {
+	if image == nil {
+		image = &registry.ContainerConfig{}
+	}
+	user, err := container.LookupUser(image.User,
+		filepath.Join(rootfs, "etc/passwd"), filepath.Join(rootfs, "etc/group"))
+	if err != nil {
+		return nil, err
+	}
+	if len(uids) > 0 && len(gids) > 0 {
+		user.DropUnmappedGroups(gids)
+	}
+	args := append(append([]string{}, image.Entrypoint...), image.Cmd...)
+	if len(args) == 0 {
+		args = []string{"/bin/sh"}
+	}
+	cwd := image.WorkingDir
+	if cwd == "" {
+		cwd = "/"
+	}
+	caps := capabilities.DefaultCaps
+
+	spec := &specs.Spec{
+		Version: specs.Version,
+		Process: &specs.Process{
+			User: specs.User{
+				UID:            user.UID,
+				GID:            user.GID,
+				AdditionalGids: user.Groups,
+			},
+			Args: args,
+			Env:  container.MergeEnv([]string{defaultPath, "HOME=" + user.Home}, image.Env),
+			Cwd:  cwd,
+			Capabilities: &specs.LinuxCapabilities{
+				Bounding:  caps,
+				Effective: caps,
+				Permitted: caps,
+			},
+			NoNewPrivileges: true,
+		},
+		Root:     &specs.Root{Path: rootPath},
+		Hostname: hostname,
+		Mounts:   defaultMounts(),
+		Linux: &specs.Linux{
+			Namespaces: []specs.LinuxNamespace{
+				{Type: specs.PIDNamespace},
+				{Type: specs.NetworkNamespace},
+				{Type: specs.IPCNamespace},
+				{Type: specs.UTSNamespace},
+				{Type: specs.MountNamespace},
+			},
+			MaskedPaths:   container.DefaultMaskedPaths,
+			ReadonlyPaths: container.DefaultReadonlyPaths,
+			Seccomp:       toSeccomp(seccomp.Resolve(seccomp.DefaultProfile(), caps)),
+		},
+	}
+	if len(uids) > 0 && len(gids) > 0 {
+		spec.Linux.Namespaces = append(spec.Linux.Namespaces, specs.LinuxNamespace{Type: specs.UserNamespace})
+		for _, m := range uids {
+			spec.Linux.UIDMappings = append(spec.Linux.UIDMappings, specIDMap(m))
+		}
+		for _, m := range gids {
+			spec.Linux.GIDMappings = append(spec.Linux.GIDMappings, specIDMap(m))
+		}
+	}
+	return spec, nil
+}
+
+// same filesystems runc spec puts into every container
+func defaultMounts() []specs.Mount {
+	return []specs.Mount{
+		{Destination: "/proc", Type: "proc", Source: "proc"},
+		{Destination: "/dev", Type: "tmpfs", Source: "tmpfs", Options: []string{"nosuid", "strictatime", "mode=755", "size=65536k"}},
+		{Destination: "/dev/pts", Type: "devpts", Source: "devpts", Options: []string{"nosuid", "noexec", "newinstance", "ptmxmode=0666", "mode=0620"}},
+		{Destination: "/dev/shm", Type: "tmpfs", Source: "shm", Options: []string{"nosuid", "noexec", "nodev", "mode=1777", "size=65536k"}},
+		{Destination: "/dev/mqueue", Type: "mqueue", Source: "mqueue", Options: []string{"nosuid", "noexec", "nodev"}},
+		{Destination: "/sys", Type: "sysfs", Source: "sysfs", Options: []string{"nosuid", "noexec", "nodev", "ro"}},
+	}
+}
+
+func specIDMap(m syscall.SysProcIDMap) specs.LinuxIDMapping {
+	return specs.LinuxIDMapping{ContainerID: uint32(m.ContainerID), HostID: uint32(m.HostID), Size: uint32(m.Size)}
+}
+
+// reverse of convertSeccomp, profile must be resolved as spec has no conditional rules
+func toSeccomp(profile *seccomp.Profile) *specs.LinuxSeccomp {
+	s := &specs.LinuxSeccomp{
+		DefaultAction:   specs.LinuxSeccompAction(profile.DefaultAction),
+		DefaultErrnoRet: profile.DefaultErrnoRet,
+	}
+	for _, a := range profile.Architectures {
+		s.Architectures = append(s.Architectures, specs.Arch(a))
+	}
+	for _, rule := range profile.Syscalls {
+		names := append([]string{}, rule.Names...)
+		if rule.Name != "" {
+			names = append(names, rule.Name)
+		}
+		sc := specs.LinuxSyscall{
+			Names:    names,
+			Action:   specs.LinuxSeccompAction(rule.Action),
+			ErrnoRet: rule.ErrnoRet,
+		}
+		for _, a := range rule.Args {
+			sc.Args = append(sc.Args, specs.LinuxSeccompArg{
+				Index:    a.Index,
+				Value:    a.Value,
+				ValueTwo: a.ValueTwo,
+				Op:       specs.LinuxSeccompOperator(a.Op),
+			})
+		}
+		s.Syscalls = append(s.Syscalls, sc)
+	}
+	return s
+}
@@ -0,0 +1,66 @@
+package oci
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/odk-/dockerinternals/container"
+	"github.com/odk-/dockerinternals/registry"
+)
+
+func TestGenerate(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootfs)
+	os.Mkdir(filepath.Join(rootfs, "etc"), 0755)
+	ioutil.WriteFile(filepath.Join(rootfs, "etc/passwd"), []byte("root:x:0:0::/root:/bin/sh\nnginx:x:101:101::/var/cache/nginx:/bin/false\n"), 0644)
+
+	image := &registry.ContainerConfig{
+		User:       "nginx",
+		Env:        []string{"NGINX_VERSION=1.25"},
+		Entrypoint: []string{"/docker-entrypoint.sh"},
+		Cmd:        []string{"nginx", "-g", "daemon off;"},
+		WorkingDir: "/srv",
+	}
+	maps := []syscall.SysProcIDMap{{ContainerID: 0, HostID: 1000, Size: 1}}
+	spec, err := Generate(image, rootfs, "rootfs", "web", maps, maps)
+	if err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	// generated spec must be runnable by us as well
+	config, err := ToConfig(spec, "/bundle")
+	if err != nil {
+		t.Fatal("Got error: ", err)
+	}
+
+	var cases = map[string][2]interface{}{
+		"rootfs":   {config.Rootfs, "/bundle/rootfs"},
+		"args":     {config.Args, []string{"/docker-entrypoint.sh", "nginx", "-g", "daemon off;"}},
+		"user":     {config.User, "101:101"},
+		"cwd":      {config.Cwd, "/srv"},
+		"env":      {config.Env, []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin", "HOME=/var/cache/nginx", "NGINX_VERSION=1.25"}},
+		"hostname": {config.Hostname, "web"},
+		"network":  {config.Network, container.NetworkNone},
+		"flags":    {config.CloneFlags, uintptr(container.DefaultCloneFlags)},
+		"uidmap":   {config.UIDMappings, maps},
+	}
+	for name, c := range cases {
+		if !reflect.DeepEqual(c[0], c[1]) {
+			t.Errorf("For %s expecting %v, got %v", name, c[1], c[0])
+		}
+	}
+	// rules for capabilities container doesn't have are left out
+	for _, rule := range spec.Linux.Seccomp.Syscalls {
+		for _, name := range rule.Names {
+			if name == "mount" {
+				t.Errorf("mount allowed without CAP_SYS_ADMIN")
+			}
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package oci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/odk-/dockerinternals/capabilities"
+	"github.com/odk-/dockerinternals/cgroup"
+	"github.com/odk-/dockerinternals/container"
+	"github.com/odk-/dockerinternals/seccomp"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+/*
+ Minimal OCI runtime support. Bundle is a directory with config.json and rootfs,
+ spec: https://github.com/opencontainers/runtime-spec/blob/main/runtime.md
+ config.json is translated into container.Config so bundles run through the same
+ nsInit code as containers started from images. Not supported: joining existing
+ namespaces, time namespace, terminal, devices.
+*/
+
+// LoadSpec reads config.json of bundle
+func LoadSpec(bundle string) (*specs.Spec, error) {
+	file, err := ioutil.ReadFile(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+	var spec = &specs.Spec{}
+	if err := json.Unmarshal(file, spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+var namespaceFlags = map[specs.LinuxNamespaceType]uintptr{
+	specs.PIDNamespace:     syscall.CLONE_NEWPID,
+	specs.NetworkNamespace: syscall.CLONE_NEWNET,
+	specs.MountNamespace:   syscall.CLONE_NEWNS,
+	specs.IPCNamespace:     syscall.CLONE_NEWIPC,
+	specs.UTSNamespace:     syscall.CLONE_NEWUTS,
+	specs.UserNamespace:    syscall.CLONE_NEWUSER,
+	specs.CgroupNamespace:  syscall.CLONE_NEWCGROUP,
+}
+
+// ToConfig translates spec into container config, relative paths are resolved against bundle
+func ToConfig(spec *specs.Spec, bundle string) (*container.Config, error) {
+	if spec.Process == nil || len(spec.Process.Args) == 0 {
+		return nil, errors.New("spec without process args")
+	}
+	if spec.Root == nil {
+		return nil, errors.New("spec without root")
+	}
+	if spec.Linux == nil {
+		return nil, errors.New("only linux containers are supported")
+	}
+	if spec.Process.Terminal {
+		return nil, errors.New("terminal is not supported, process uses stdio of cntcli")
+	}
+
+	config := &container.Config{
+		Rootfs:          bundlePath(bundle, spec.Root.Path),
+		Args:            spec.Process.Args,
+		Env:             spec.Process.Env,
+		Cwd:             spec.Process.Cwd,
+		Hostname:        spec.Hostname,
+		ReadOnly:        spec.Root.Readonly,
+		User:            fmt.Sprintf("%d:%d", spec.Process.User.UID, spec.Process.User.GID),
+		AdditionalGids:  spec.Process.User.AdditionalGids,
+		NoNewPrivileges: spec.Process.NoNewPrivileges,
+		MaskedPaths:     spec.Linux.MaskedPaths,
+		ReadonlyPaths:   spec.Linux.ReadonlyPaths,
+		Network:         container.NetworkNone,
+	}
+
+	// same as runc, process keeps capabilities it has when spec doesn't set them
+	if c := spec.Process.Capabilities; c != nil {
+		sets := &capabilities.Sets{}
+		for _, s := range []struct {
+			names []string
+			set   *[]string
+		}{
+			{c.Bounding, &sets.Bounding},
+			{c.Effective, &sets.Effective},
+			{c.Permitted, &sets.Permitted},
+			{c.Inheritable, &sets.Inheritable},
+			{c.Ambient, &sets.Ambient},
+		} {
+			caps, err := capabilities.Merge(nil, s.names, nil)
+			if err != nil {
+				return nil, err
+			}
+			*s.set = caps
+		}
+		config.Capabilities, config.CapabilitySets = sets.Bounding, sets
+	} else {
+		config.KeepCapabilities = true
+	}
+
+	for _, r := range spec.Process.Rlimits {
+		t, ok := rlimits[r.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown rlimit %s", r.Type)
+		}
+		config.Rlimits = append(config.Rlimits, container.Rlimit{Type: t, Hard: r.Hard, Soft: r.Soft})
+	}
+
+	if spec.Hooks != nil {
+		config.CreateContainerHooks = spec.Hooks.CreateContainer
+		config.StartContainerHooks = spec.Hooks.StartContainer
+	}
+
+	for _, ns := range spec.Linux.Namespaces {
+		if ns.Path != "" {
+			return nil, fmt.Errorf("joining existing %s namespace is not supported", ns.Type)
+		}
+		flag, ok := namespaceFlags[ns.Type]
+		if !ok {
+			return nil, fmt.Errorf("%s namespace is not supported", ns.Type)
+		}
+		config.CloneFlags |= flag
+	}
+	// pivot_root in mount namespace of the host would be a disaster
+	if config.CloneFlags&syscall.CLONE_NEWNS == 0 {
+		return nil, errors.New("mount namespace is required")
+	}
+	if config.CloneFlags&syscall.CLONE_NEWNET == 0 {
+		config.Network = container.NetworkHost
+	}
+	for _, m := range spec.Linux.UIDMappings {
+		config.UIDMappings = append(config.UIDMappings, idMap(m))
+	}
+	for _, m := range spec.Linux.GIDMappings {
+		config.GIDMappings = append(config.GIDMappings, idMap(m))
+	}
+
+	for _, m := range spec.Mounts {
+		flags, data, bind := parseMountOptions(m.Options)
+		if m.Type == "bind" || bind {
+			config.Mounts = append(config.Mounts, container.Mount{
+				Source:      bundlePath(bundle, m.Source),
+				Destination: m.Destination,
+				ReadOnly:    flags&syscall.MS_RDONLY != 0,
+			})
+			continue
+		}
+		config.FsMounts = append(config.FsMounts, container.FsMount{
+			Source:      m.Source,
+			Destination: m.Destination,
+			Type:        m.Type,
+			Flags:       flags,
+			Data:        data,
+		})
+	}
+
+	if spec.Linux.Seccomp != nil {
+		config.Seccomp = convertSeccomp(spec.Linux.Seccomp)
+	}
+	return config, nil
+}
+
+// ToResources translates linux.resources into cgroup limits
+func ToResources(spec *specs.Spec) *cgroup.Resources {
+	r := &cgroup.Resources{}
+	if spec.Linux == nil || spec.Linux.Resources == nil {
+		return r
+	}
+	res := spec.Linux.Resources
+	if res.Memory != nil {
+		if res.Memory.Limit != nil && *res.Memory.Limit > 0 {
+			r.Memory = *res.Memory.Limit
+		}
+		if res.Memory.Swap != nil {
+			r.MemorySwap = *res.Memory.Swap
+		}
+	}
+	if res.CPU != nil {
+		if res.CPU.Shares != nil {
+			r.CPUShares = *res.CPU.Shares
+		}
+		if res.CPU.Quota != nil && *res.CPU.Quota > 0 {
+			period := uint64(100000)
+			if res.CPU.Period != nil && *res.CPU.Period > 0 {
+				period = *res.CPU.Period
+			}
+			r.CPUs = float64(*res.CPU.Quota) / float64(period)
+		}
+	}
+	if res.Pids != nil && res.Pids.Limit > 0 {
+		r.PidsLimit = res.Pids.Limit
+	}
+	// blkio weight 10-1000 converted to io.weight 1-10000 same way as runc does
+	if res.BlockIO != nil && res.BlockIO.Weight != nil && *res.BlockIO.Weight >= 10 {
+		r.IOWeight = 1 + (uint64(*res.BlockIO.Weight)-10)*9999/990
+	}
+	return r
+}
+
+var rlimits = map[string]int{
+	"RLIMIT_AS":         unix.RLIMIT_AS,
+	"RLIMIT_CORE":       unix.RLIMIT_CORE,
+	"RLIMIT_CPU":        unix.RLIMIT_CPU,
+	"RLIMIT_DATA":       unix.RLIMIT_DATA,
+	"RLIMIT_FSIZE":      unix.RLIMIT_FSIZE,
+	"RLIMIT_LOCKS":      unix.RLIMIT_LOCKS,
+	"RLIMIT_MEMLOCK":    unix.RLIMIT_MEMLOCK,
+	"RLIMIT_MSGQUEUE":   unix.RLIMIT_MSGQUEUE,
+	"RLIMIT_NICE":       unix.RLIMIT_NICE,
+	"RLIMIT_NOFILE":     unix.RLIMIT_NOFILE,
+	"RLIMIT_NPROC":      unix.RLIMIT_NPROC,
+	"RLIMIT_RSS":        unix.RLIMIT_RSS,
+	"RLIMIT_RTPRIO":     unix.RLIMIT_RTPRIO,
+	"RLIMIT_RTTIME":     unix.RLIMIT_RTTIME,
+	"RLIMIT_SIGPENDING": unix.RLIMIT_SIGPENDING,
+	"RLIMIT_STACK":      unix.RLIMIT_STACK,
+}
+
+func bundlePath(bundle, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(bundle, path)
+}
+
+func idMap(m specs.LinuxIDMapping) syscall.SysProcIDMap {
+	return syscall.SysProcIDMap{ContainerID: int(m.ContainerID), HostID: int(m.HostID), Size: int(m.Size)}
+}
+
+// fstab style options, flag name and whether it clears the flag
+var mountFlags = map[string]struct {
+	clear bool
+	flag  uintptr
+}{
+	"ro":          {false, syscall.MS_RDONLY},
+	"rw":          {true, syscall.MS_RDONLY},
+	"nosuid":      {false, syscall.MS_NOSUID},
+	"suid":        {true, syscall.MS_NOSUID},
+	"nodev":       {false, syscall.MS_NODEV},
+	"dev":         {true, syscall.MS_NODEV},
+	"noexec":      {false, syscall.MS_NOEXEC},
+	"exec":        {true, syscall.MS_NOEXEC},
+	"noatime":     {false, syscall.MS_NOATIME},
+	"atime":       {true, syscall.MS_NOATIME},
+	"relatime":    {false, syscall.MS_RELATIME},
+	"strictatime": {false, syscall.MS_STRICTATIME},
+	"sync":        {false, syscall.MS_SYNCHRONOUS},
+	"async":       {true, syscall.MS_SYNCHRONOUS},
+}
+
+// splits options into mount flags and data for filesystem. Propagation options are ignored,
+// container mounts are private anyway
+func parseMountOptions(options []string) (flags uintptr, data string, bind bool) {
+	var rest []string
+	for _, o := range options {
+		if f, ok := mountFlags[o]; ok {
+			if f.clear {
+				flags &^= f.flag
+			} else {
+				flags |= f.flag
+			}
+			continue
+		}
+		switch o {
+		case "bind", "rbind":
+			bind = true
+		case "private", "rprivate", "shared", "rshared", "slave", "rslave", "unbindable", "runbindable":
+		default:
+			rest = append(rest, o)
+		}
+	}
+	return flags, strings.Join(rest, ","), bind
+}
+
+// OCI seccomp is docker profile without conditional rules
+func convertSeccomp(s *specs.LinuxSeccomp) *seccomp.Profile {
+	profile := &seccomp.Profile{
+		DefaultAction:   seccomp.Action(s.DefaultAction),
+		DefaultErrnoRet: s.DefaultErrnoRet,
+	}
+	for _, a := range s.Architectures {
+		profile.Architectures = append(profile.Architectures, string(a))
+	}
+	for _, sc := range s.Syscalls {
+		rule := seccomp.Syscall{
+			Names:    sc.Names,
+			Action:   seccomp.Action(sc.Action),
+			ErrnoRet: sc.ErrnoRet,
+		}
+		for _, a := range sc.Args {
+			rule.Args = append(rule.Args, seccomp.Arg{
+				Index:    a.Index,
+				Value:    a.Value,
+				ValueTwo: a.ValueTwo,
+				Op:       seccomp.Operator(a.Op),
+			})
+		}
+		profile.Syscalls = append(profile.Syscalls, rule)
+	}
+	return profile
+}
@@ -0,0 +1,107 @@
+package oci
+
+import (
+	"encoding/json"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/odk-/dockerinternals/capabilities"
+	"github.com/odk-/dockerinternals/container"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// trimmed down output of runc spec
+const testSpec = `{
+	"ociVersion": "1.0.2",
+	"process": {
+		"user": {"uid": 1000, "gid": 100, "additionalGids": [10]},
+		"args": ["sh", "-c", "echo hi"],
+		"env": ["PATH=/bin", "TERM=xterm"],
+		"cwd": "/srv",
+		"capabilities": {"bounding": ["CAP_KILL", "CAP_NET_BIND_SERVICE"], "permitted": ["CAP_KILL"], "effective": ["CAP_KILL"]},
+		"rlimits": [{"type": "RLIMIT_NOFILE", "hard": 1024, "soft": 512}],
+		"noNewPrivileges": true
+	},
+	"hooks": {"createContainer": [{"path": "/bin/true"}]},
+	"root": {"path": "rootfs", "readonly": true},
+	"hostname": "oci",
+	"mounts": [
+		{"destination": "/proc", "type": "proc", "source": "proc"},
+		{"destination": "/dev", "type": "tmpfs", "source": "tmpfs", "options": ["nosuid", "strictatime", "mode=755", "size=65536k"]},
+		{"destination": "/data", "type": "bind", "source": "data", "options": ["rbind", "ro", "rprivate"]}
+	],
+	"linux": {
+		"resources": {
+			"memory": {"limit": 1048576},
+			"cpu": {"quota": 50000, "period": 100000},
+			"pids": {"limit": 64},
+			"blockIO": {"weight": 1000}
+		},
+		"namespaces": [{"type": "pid"}, {"type": "ipc"}, {"type": "uts"}, {"type": "mount"}],
+		"maskedPaths": ["/proc/kcore"],
+		"readonlyPaths": ["/proc/sys"]
+	}
+}`
+
+func TestToConfig(t *testing.T) {
+	var spec = &specs.Spec{}
+	if err := json.Unmarshal([]byte(testSpec), spec); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ToConfig(spec, "/bundle")
+	if err != nil {
+		t.Fatal("Got error: ", err)
+	}
+
+	var cases = map[string][2]interface{}{
+		"rootfs":  {config.Rootfs, "/bundle/rootfs"},
+		"user":    {config.User, "1000:100"},
+		"cwd":     {config.Cwd, "/srv"},
+		"gids":    {config.AdditionalGids, []uint32{10}},
+		"rlimits": {config.Rlimits, []container.Rlimit{{Type: syscall.RLIMIT_NOFILE, Hard: 1024, Soft: 512}}},
+		"hooks":   {config.CreateContainerHooks, []specs.Hook{{Path: "/bin/true"}}},
+		"caps":    {config.Capabilities, []string{"CAP_KILL", "CAP_NET_BIND_SERVICE"}},
+		"capsets": {config.CapabilitySets, &capabilities.Sets{
+			Bounding:  []string{"CAP_KILL", "CAP_NET_BIND_SERVICE"},
+			Effective: []string{"CAP_KILL"},
+			Permitted: []string{"CAP_KILL"},
+		}},
+		"keepcaps":   {config.KeepCapabilities, false},
+		"network":    {config.Network, container.NetworkHost},
+		"cloneflags": {config.CloneFlags, uintptr(syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS | syscall.CLONE_NEWNS)},
+		"mounts":     {config.Mounts, []container.Mount{{Source: "/bundle/data", Destination: "/data", ReadOnly: true}}},
+		"fsmounts": {config.FsMounts, []container.FsMount{
+			{Source: "proc", Destination: "/proc", Type: "proc"},
+			{Source: "tmpfs", Destination: "/dev", Type: "tmpfs", Flags: syscall.MS_NOSUID | syscall.MS_STRICTATIME, Data: "mode=755,size=65536k"},
+		}},
+	}
+	for name, c := range cases {
+		if !reflect.DeepEqual(c[0], c[1]) {
+			t.Errorf("For %s expecting %v, got %v", name, c[1], c[0])
+		}
+	}
+
+	res := ToResources(spec)
+	if res.Memory != 1048576 || res.CPUs != 0.5 || res.PidsLimit != 64 || res.IOWeight != 10000 {
+		t.Errorf("Wrong resources %+v", res)
+	}
+
+	// capabilities missing in spec are kept, not dropped
+	spec.Process.Capabilities = nil
+	if config, err = ToConfig(spec, "/bundle"); err != nil || !config.KeepCapabilities || config.CapabilitySets != nil {
+		t.Errorf("Capabilities of spec without them not kept, got %+v %v", config, err)
+	}
+
+	spec.Process.Rlimits[0].Type = "RLIMIT_FOO"
+	if _, err := ToConfig(spec, "/bundle"); err == nil {
+		t.Errorf("Spec with unknown rlimit accepted")
+	}
+	spec.Process.Rlimits = nil
+
+	spec.Linux.Namespaces = spec.Linux.Namespaces[:3]
+	if _, err := ToConfig(spec, "/bundle"); err == nil {
+		t.Errorf("Spec without mount namespace accepted")
+	}
+}
@@ -0,0 +1,74 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/odk-/dockerinternals/container"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// our statuses as named by OCI, paused is not in spec but runc reports it too
+var statuses = map[string]specs.ContainerState{
+	container.StatusCreated: specs.StateCreated,
+	container.StatusRunning: specs.StateRunning,
+	container.StatusPaused:  "paused",
+	container.StatusExited:  specs.StateStopped,
+}
+
+// State returns OCI state of container
+func State(state *container.State) *specs.State {
+	s := &specs.State{
+		Version: specs.Version,
+		ID:      state.Name,
+		Status:  statuses[state.Status],
+		Bundle:  state.Bundle,
+	}
+	if state.Status != container.StatusExited {
+		s.Pid = state.Pid
+	}
+	return s
+}
+
+// RunHooks runs hooks one by one with container state on stdin, first failure stops them
+func RunHooks(hooks []specs.Hook, state *specs.State) error {
+	j, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	for _, h := range hooks {
+		if err := runHook(h, j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// timer of hook timeout is released as soon as hook is done
+func runHook(h specs.Hook, state []byte) error {
+	ctx := context.Background()
+	if h.Timeout != nil && *h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(*h.Timeout)*time.Second)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, h.Path)
+	// args include argv[0] as in execve
+	if len(h.Args) > 0 {
+		cmd.Args = h.Args
+	}
+	cmd.Env = h.Env
+	cmd.Stdin = bytes.NewReader(state)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("hook %s: %s", h.Path, err)
+	}
+	return nil
+}
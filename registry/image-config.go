@@ -0,0 +1,40 @@
+package registry
+
+import (
+	"encoding/json"
+)
+
+// ImageConfig holds parsed image configuration json referenced by manifest Config.Digest.
+// Only parts we use are here, full spec: https://github.com/moby/moby/blob/master/image/spec/v1.2.md
+type ImageConfig struct {
+	Architecture string          `json:"architecture,omitempty"`
+	OS           string          `json:"os,omitempty"`
+	Config       ContainerConfig `json:"config"`
+}
+
+// ContainerConfig holds defaults for containers created from image
+type ContainerConfig struct {
+	User         string              `json:"User,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+}
+
+// GetImageConfig downloads image configuration blob
+func GetImageConfig(img *Image, digest string) (*ImageConfig, error) {
+	blob, err := GetBlob(img, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	var config ImageConfig
+	decoder := json.NewDecoder(blob)
+	err = decoder.Decode(&config)
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
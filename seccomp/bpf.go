@@ -0,0 +1,249 @@
+package seccomp
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+ Filter program layout:
+	load arch; if not native kill process
+	load syscall number; if x32 syscall kill process
+	for every syscall of every rule:
+		if nr != syscall skip block
+		compare args (if any), if some doesn't match skip block
+		return rule action
+		(only after blocks with args) load syscall number again
+	return default action
+
+ Kernel gives us struct seccomp_data, classic BPF works on 32 bit words
+ so 64 bit args are compared in two steps, high word first. Both supported
+ architectures are little endian so low word is at lower offset.
+*/
+
+// offsets in struct seccomp_data
+const (
+	offsetNr   = 0
+	offsetArch = 4
+	offsetArgs = 16
+)
+
+// kernel refuses longer programs
+const maxInsns = 4096
+
+// jump targets used while building rule block, resolved into offsets later.
+// Positive numbers are plain relative jumps.
+const (
+	// fall through to next instruction
+	labelNext = 0
+	// argument matches, continue with next argument
+	labelPass = -1
+	// rule doesn't match, skip rest of the block
+	labelFail = -2
+)
+
+type insn struct {
+	code   uint16
+	k      uint32
+	jt, jf int
+}
+
+func stmt(code uint16, k uint32) insn {
+	return insn{code: code, k: k}
+}
+
+func jump(code uint16, k uint32, jt, jf int) insn {
+	return insn{code: code, k: k, jt: jt, jf: jf}
+}
+
+const (
+	ldAbs = unix.BPF_LD | unix.BPF_W | unix.BPF_ABS
+	jeq   = unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K
+	jgt   = unix.BPF_JMP | unix.BPF_JGT | unix.BPF_K
+	jge   = unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K
+	and   = unix.BPF_ALU | unix.BPF_AND | unix.BPF_K
+	ret   = unix.BPF_RET | unix.BPF_K
+)
+
+// compile turns profile into BPF program
+func compile(profile *Profile, caps []string) ([]unix.SockFilter, error) {
+	defaultRet, err := actionRet(profile.DefaultAction, profile.DefaultErrnoRet)
+	if err != nil {
+		return nil, err
+	}
+
+	// we know syscall numbers of native architecture only
+	header := []insn{
+		stmt(ldAbs, offsetArch),
+		jump(jeq, nativeArch, 1, 0),
+		stmt(ret, unix.SECCOMP_RET_KILL_PROCESS),
+		stmt(ldAbs, offsetNr),
+	}
+	if x32SyscallBit != 0 {
+		header = append(header,
+			jump(jge, x32SyscallBit, 0, 1),
+			stmt(ret, unix.SECCOMP_RET_KILL_PROCESS))
+	}
+	prog, err := assemble(header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range profile.Syscalls {
+		if !rule.applies(caps) {
+			continue
+		}
+		action, err := actionRet(rule.Action, rule.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+		names := rule.Names
+		if rule.Name != "" {
+			names = append(names, rule.Name)
+		}
+		for _, name := range names {
+			nr, ok := syscallTable[name]
+			if !ok {
+				// profiles list syscalls of all architectures, same as libseccomp we skip unknown ones
+				continue
+			}
+			block, err := ruleBlock(nr, rule.Args, action)
+			if err != nil {
+				return nil, fmt.Errorf("syscall %s: %s", name, err)
+			}
+			prog = append(prog, block...)
+		}
+	}
+	prog = append(prog, unix.SockFilter{Code: ret, K: defaultRet})
+
+	if len(prog) > maxInsns {
+		return nil, fmt.Errorf("seccomp filter too big: %d instructions", len(prog))
+	}
+	return prog, nil
+}
+
+// block matching single syscall. Expects syscall number in accumulator and leaves it there
+func ruleBlock(nr uint32, args []Arg, action uint32) ([]unix.SockFilter, error) {
+	if len(args) == 0 {
+		return assemble([]insn{
+			jump(jeq, nr, 0, 1),
+			stmt(ret, action),
+		}, nil)
+	}
+
+	block := []insn{jump(jeq, nr, labelNext, labelFail)}
+	passAt := []int{0}
+	for _, a := range args {
+		argInsns, err := compareArg(a)
+		if err != nil {
+			return nil, err
+		}
+		end := len(block) + len(argInsns)
+		for _, i := range argInsns {
+			block = append(block, i)
+			passAt = append(passAt, end)
+		}
+	}
+	// arg comparisons overwrite accumulator, failed match lands on reload of syscall number
+	block = append(block, stmt(ret, action), stmt(ldAbs, offsetNr))
+	passAt = append(passAt, 0, 0)
+	return assemble(block, passAt)
+}
+
+// instructions comparing single 64 bit argument
+func compareArg(a Arg) ([]insn, error) {
+	if a.Index > 5 {
+		return nil, fmt.Errorf("invalid argument index %d", a.Index)
+	}
+	ldLo := stmt(ldAbs, uint32(offsetArgs+8*a.Index))
+	ldHi := stmt(ldAbs, uint32(offsetArgs+8*a.Index+4))
+	hi, lo := uint32(a.Value>>32), uint32(a.Value)
+
+	switch a.Op {
+	case OpEqualTo:
+		return []insn{ldHi, jump(jeq, hi, labelNext, labelFail), ldLo, jump(jeq, lo, labelNext, labelFail)}, nil
+	case OpNotEqual:
+		return []insn{ldHi, jump(jeq, hi, labelNext, labelPass), ldLo, jump(jeq, lo, labelFail, labelPass)}, nil
+	case OpMaskedEqual:
+		// Value is mask here, ValueTwo is expected result
+		vhi, vlo := uint32(a.ValueTwo>>32), uint32(a.ValueTwo)
+		return []insn{
+			ldHi, stmt(and, hi), jump(jeq, vhi, labelNext, labelFail),
+			ldLo, stmt(and, lo), jump(jeq, vlo, labelNext, labelFail),
+		}, nil
+	case OpGreaterThan:
+		return []insn{ldHi, jump(jgt, hi, labelPass, labelNext), jump(jeq, hi, labelNext, labelFail), ldLo, jump(jgt, lo, labelPass, labelFail)}, nil
+	case OpGreaterEqual:
+		return []insn{ldHi, jump(jgt, hi, labelPass, labelNext), jump(jeq, hi, labelNext, labelFail), ldLo, jump(jge, lo, labelPass, labelFail)}, nil
+	case OpLessThan:
+		return []insn{ldHi, jump(jgt, hi, labelFail, labelNext), jump(jeq, hi, labelNext, labelPass), ldLo, jump(jge, lo, labelFail, labelPass)}, nil
+	case OpLessEqual:
+		return []insn{ldHi, jump(jgt, hi, labelFail, labelNext), jump(jeq, hi, labelNext, labelPass), ldLo, jump(jgt, lo, labelFail, labelPass)}, nil
+	}
+	return nil, fmt.Errorf("unsupported operator %q", a.Op)
+}
+
+// resolves labels into relative jumps. Fail points to the last instruction of block,
+// pass to index from passAt
+func assemble(block []insn, passAt []int) ([]unix.SockFilter, error) {
+	failAt := len(block) - 1
+	prog := make([]unix.SockFilter, len(block))
+	for pos, i := range block {
+		offset := func(label int) (uint8, error) {
+			if i.code&0x07 != unix.BPF_JMP {
+				return 0, nil
+			}
+			var target int
+			switch label {
+			case labelPass:
+				target = passAt[pos]
+			case labelFail:
+				target = failAt
+			default:
+				return uint8(label), nil
+			}
+			off := target - pos - 1
+			if off < 0 || off > 255 {
+				return 0, fmt.Errorf("jump out of range")
+			}
+			return uint8(off), nil
+		}
+		jt, err := offset(i.jt)
+		if err != nil {
+			return nil, err
+		}
+		jf, err := offset(i.jf)
+		if err != nil {
+			return nil, err
+		}
+		prog[pos] = unix.SockFilter{Code: i.code, Jt: jt, Jf: jf, K: i.k}
+	}
+	return prog, nil
+}
+
+// converts action into seccomp return value
+func actionRet(action Action, errnoRet *uint) (uint32, error) {
+	// docker returns EPERM unless profile says otherwise
+	data := uint32(unix.EPERM)
+	if errnoRet != nil {
+		data = uint32(*errnoRet)
+	}
+	switch action {
+	case ActKill, ActKillThread:
+		return unix.SECCOMP_RET_KILL_THREAD, nil
+	case ActKillProcess:
+		return unix.SECCOMP_RET_KILL_PROCESS, nil
+	case ActTrap:
+		return unix.SECCOMP_RET_TRAP, nil
+	case ActErrno:
+		return unix.SECCOMP_RET_ERRNO | (data & unix.SECCOMP_RET_DATA), nil
+	case ActTrace:
+		return unix.SECCOMP_RET_TRACE | (data & unix.SECCOMP_RET_DATA), nil
+	case ActAllow:
+		return unix.SECCOMP_RET_ALLOW, nil
+	case ActLog:
+		return unix.SECCOMP_RET_LOG, nil
+	}
+	return 0, fmt.Errorf("unsupported seccomp action %q", action)
+}
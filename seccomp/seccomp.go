@@ -0,0 +1,211 @@
+package seccomp
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+ Syscall filtering compatible with docker seccomp JSON profiles.
+ We don't use libseccomp (it would need cgo), instead profile is compiled
+ into classic BPF program by hand, see bpf.go. Only syscalls of native
+ architecture are supported, everything else kills the process.
+ more about seccomp: https://www.kernel.org/doc/Documentation/prctl/seccomp_filter.txt
+*/
+
+// Action taken when syscall matches a rule
+type Action string
+
+// actions as named in docker profiles
+const (
+	ActKill        Action = "SCMP_ACT_KILL"
+	ActKillProcess Action = "SCMP_ACT_KILL_PROCESS"
+	ActKillThread  Action = "SCMP_ACT_KILL_THREAD"
+	ActTrap        Action = "SCMP_ACT_TRAP"
+	ActErrno       Action = "SCMP_ACT_ERRNO"
+	ActTrace       Action = "SCMP_ACT_TRACE"
+	ActAllow       Action = "SCMP_ACT_ALLOW"
+	ActLog         Action = "SCMP_ACT_LOG"
+)
+
+// Operator used to compare syscall argument
+type Operator string
+
+// comparison operators as named in docker profiles
+const (
+	OpNotEqual     Operator = "SCMP_CMP_NE"
+	OpLessThan     Operator = "SCMP_CMP_LT"
+	OpLessEqual    Operator = "SCMP_CMP_LE"
+	OpEqualTo      Operator = "SCMP_CMP_EQ"
+	OpGreaterEqual Operator = "SCMP_CMP_GE"
+	OpGreaterThan  Operator = "SCMP_CMP_GT"
+	OpMaskedEqual  Operator = "SCMP_CMP_MASKED_EQ"
+)
+
+// Profile is docker compatible seccomp profile
+type Profile struct {
+	DefaultAction   Action    `json:"defaultAction"`
+	DefaultErrnoRet *uint     `json:"defaultErrnoRet,omitempty"`
+	Architectures   []string  `json:"architectures,omitempty"`
+	Syscalls        []Syscall `json:"syscalls"`
+}
+
+// Syscall is a rule applied to list of syscalls
+type Syscall struct {
+	Names []string `json:"names,omitempty"`
+	// older profiles use single name per rule
+	Name     string `json:"name,omitempty"`
+	Action   Action `json:"action"`
+	ErrnoRet *uint  `json:"errnoRet,omitempty"`
+	// all args must match for rule to apply
+	Args     []Arg  `json:"args,omitempty"`
+	Includes Filter `json:"includes,omitempty"`
+	Excludes Filter `json:"excludes,omitempty"`
+}
+
+// Arg compares syscall argument at Index, ValueTwo is used only by masked equal as value while Value is a mask
+type Arg struct {
+	Index    uint     `json:"index"`
+	Value    uint64   `json:"value"`
+	ValueTwo uint64   `json:"valueTwo,omitempty"`
+	Op       Operator `json:"op"`
+}
+
+// Filter decides if rule is used for given container. Includes need all conditions met, excludes any
+type Filter struct {
+	Caps      []string `json:"caps,omitempty"`
+	Arches    []string `json:"arches,omitempty"`
+	MinKernel string   `json:"minKernel,omitempty"`
+}
+
+// LoadProfile reads docker seccomp JSON profile from file
+func LoadProfile(path string) (*Profile, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var profile = &Profile{}
+	if err := json.Unmarshal(file, profile); err != nil {
+		return nil, err
+	}
+	if profile.DefaultAction == "" {
+		return nil, errors.New("seccomp profile without defaultAction")
+	}
+	return profile, nil
+}
+
+// Install compiles profile and loads it for all threads of current process.
+// caps is list of capabilities container will keep, used by includes/excludes of rules.
+// Filter survives fork and exec so it should be installed right before running user command.
+func Install(profile *Profile, caps []string) error {
+	if len(syscallTable) == 0 {
+		return errors.New("seccomp is not supported on " + runtime.GOARCH)
+	}
+	filter, err := compile(profile, caps)
+	if err != nil {
+		return err
+	}
+	prog := unix.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+	// TSYNC applies filter to all threads, go runtime has many of them
+	_, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Resolve returns copy of profile with only rules used for container with caps.
+// Result has no includes and excludes, so it can be handed to runtimes which don't know them
+func Resolve(profile *Profile, caps []string) *Profile {
+	resolved := &Profile{
+		DefaultAction:   profile.DefaultAction,
+		DefaultErrnoRet: profile.DefaultErrnoRet,
+		Architectures:   profile.Architectures,
+	}
+	for _, rule := range profile.Syscalls {
+		if !rule.applies(caps) {
+			continue
+		}
+		rule.Includes, rule.Excludes = Filter{}, Filter{}
+		resolved.Syscalls = append(resolved.Syscalls, rule)
+	}
+	return resolved
+}
+
+// checks if rule should be part of filter for this container
+func (s *Syscall) applies(caps []string) bool {
+	inc, exc := s.Includes, s.Excludes
+	for _, c := range inc.Caps {
+		if !contains(caps, c) {
+			return false
+		}
+	}
+	if len(inc.Arches) > 0 && !contains(inc.Arches, runtime.GOARCH) {
+		return false
+	}
+	if inc.MinKernel != "" && !kernelAtLeast(inc.MinKernel) {
+		return false
+	}
+	for _, c := range exc.Caps {
+		if contains(caps, c) {
+			return false
+		}
+	}
+	if contains(exc.Arches, runtime.GOARCH) {
+		return false
+	}
+	if exc.MinKernel != "" && kernelAtLeast(exc.MinKernel) {
+		return false
+	}
+	return true
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// compares running kernel with version like 4.8
+func kernelAtLeast(version string) bool {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return false
+	}
+	return compareVersions(unix.ByteSliceToString(uts.Release[:]), version) >= 0
+}
+
+// compares only major and minor numbers, release can be 5.15.0-91-generic
+func compareVersions(release, version string) int {
+	r := strings.SplitN(release, ".", 3)
+	v := strings.SplitN(version, ".", 3)
+	for i := 0; i < 2; i++ {
+		var a, b int
+		if i < len(r) {
+			a, _ = strconv.Atoi(strings.TrimFunc(r[i], func(c rune) bool { return c < '0' || c > '9' }))
+		}
+		if i < len(v) {
+			b, _ = strconv.Atoi(v[i])
+		}
+		if a != b {
+			if a < b {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,127 @@
+package seccomp
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// minimal classic BPF interpreter, handles only instructions produced by compile
+func run(t *testing.T, prog []unix.SockFilter, arch, nr uint32, args [6]uint64) uint32 {
+	data := make([]byte, 64)
+	binary.LittleEndian.PutUint32(data[offsetNr:], nr)
+	binary.LittleEndian.PutUint32(data[offsetArch:], arch)
+	for i, a := range args {
+		binary.LittleEndian.PutUint64(data[offsetArgs+8*i:], a)
+	}
+	var acc uint32
+	for pc := 0; pc < len(prog); pc++ {
+		i := prog[pc]
+		switch i.Code {
+		case ldAbs:
+			acc = binary.LittleEndian.Uint32(data[i.K:])
+		case and:
+			acc &= i.K
+		case ret:
+			return i.K
+		case jeq, jgt, jge:
+			var cond bool
+			switch i.Code {
+			case jeq:
+				cond = acc == i.K
+			case jgt:
+				cond = acc > i.K
+			case jge:
+				cond = acc >= i.K
+			}
+			if cond {
+				pc += int(i.Jt)
+			} else {
+				pc += int(i.Jf)
+			}
+		default:
+			t.Fatalf("unexpected instruction %v", i)
+		}
+	}
+	t.Fatal("program ended without return")
+	return 0
+}
+
+func TestCompileArgs(t *testing.T) {
+	nr := syscallTable["personality"]
+	other := syscallTable["getpid"]
+	eperm := unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)
+
+	var cases = map[Operator][]struct {
+		arg  uint64
+		want uint32
+	}{
+		OpEqualTo:      {{0x100000005, unix.SECCOMP_RET_ALLOW}, {5, eperm}, {0x100000006, eperm}},
+		OpNotEqual:     {{0x100000005, eperm}, {5, unix.SECCOMP_RET_ALLOW}, {0x100000006, unix.SECCOMP_RET_ALLOW}},
+		OpGreaterThan:  {{0x100000005, eperm}, {0x100000006, unix.SECCOMP_RET_ALLOW}, {0x200000000, unix.SECCOMP_RET_ALLOW}, {0xffffffff, eperm}},
+		OpGreaterEqual: {{0x100000005, unix.SECCOMP_RET_ALLOW}, {0x100000004, eperm}, {0x200000000, unix.SECCOMP_RET_ALLOW}},
+		OpLessThan:     {{0x100000005, eperm}, {0x100000004, unix.SECCOMP_RET_ALLOW}, {0xffffffff, unix.SECCOMP_RET_ALLOW}, {0x200000000, eperm}},
+		OpLessEqual:    {{0x100000005, unix.SECCOMP_RET_ALLOW}, {0x100000006, eperm}, {5, unix.SECCOMP_RET_ALLOW}},
+	}
+
+	for op, checks := range cases {
+		profile := &Profile{
+			DefaultAction: ActErrno,
+			Syscalls: []Syscall{
+				{Names: []string{"personality"}, Action: ActAllow, Args: []Arg{{Index: 1, Value: 0x100000005, Op: op}}},
+				{Names: []string{"getpid"}, Action: ActAllow},
+			},
+		}
+		prog, err := compile(profile, nil)
+		if err != nil {
+			t.Fatal("Got error: ", err)
+		}
+		for _, c := range checks {
+			if got := run(t, prog, nativeArch, nr, [6]uint64{0, c.arg}); got != c.want {
+				t.Errorf("%s: for arg %#x expecting %#x, got %#x", op, c.arg, c.want, got)
+			}
+		}
+		// rule after one with args must still see syscall number
+		if got := run(t, prog, nativeArch, other, [6]uint64{}); got != unix.SECCOMP_RET_ALLOW {
+			t.Errorf("%s: rule after args block broken, got %#x", op, got)
+		}
+		if got := run(t, prog, nativeArch+1, other, [6]uint64{}); got != unix.SECCOMP_RET_KILL_PROCESS {
+			t.Errorf("%s: foreign arch not killed, got %#x", op, got)
+		}
+	}
+}
+
+func TestDefaultProfile(t *testing.T) {
+	prog, err := compile(DefaultProfile(), nil)
+	if err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	var cases = map[string]uint32{
+		"read":   unix.SECCOMP_RET_ALLOW,
+		"execve": unix.SECCOMP_RET_ALLOW,
+		"mount":  unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM),
+		"clone3": unix.SECCOMP_RET_ERRNO | uint32(unix.ENOSYS),
+	}
+	for name, want := range cases {
+		if got := run(t, prog, nativeArch, syscallTable[name], [6]uint64{}); got != want {
+			t.Errorf("For %s expecting %#x, got %#x", name, want, got)
+		}
+	}
+	// clone creating namespace is denied without CAP_SYS_ADMIN
+	clone := syscallTable["clone"]
+	if got := run(t, prog, nativeArch, clone, [6]uint64{uint64(unix.SIGCHLD)}); got != unix.SECCOMP_RET_ALLOW {
+		t.Errorf("plain clone expected to be allowed, got %#x", got)
+	}
+	if got := run(t, prog, nativeArch, clone, [6]uint64{unix.CLONE_NEWNS}); got == unix.SECCOMP_RET_ALLOW {
+		t.Errorf("clone with CLONE_NEWNS expected to be denied")
+	}
+
+	prog, err = compile(DefaultProfile(), []string{"CAP_SYS_ADMIN"})
+	if err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	if got := run(t, prog, nativeArch, syscallTable["mount"], [6]uint64{}); got != unix.SECCOMP_RET_ALLOW {
+		t.Errorf("mount with CAP_SYS_ADMIN expected to be allowed, got %#x", got)
+	}
+}
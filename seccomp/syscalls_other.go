@@ -0,0 +1,12 @@
+//go:build !amd64 && !arm64
+// +build !amd64,!arm64
+
+package seccomp
+
+// syscall tables are provided only for amd64 and arm64, elsewhere Install fails
+const (
+	nativeArch    = 0
+	x32SyscallBit = 0
+)
+
+var syscallTable = map[string]uint32{}
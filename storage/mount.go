@@ -1,7 +1,10 @@
 package storage
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"syscall"
@@ -31,6 +34,43 @@ func mountImageOverlay(manifest *registry.DockerManifest, target string) error {
 	return nil
 }
 
+// file in container directory with overlay options for MountRootFS
+const overlayOptionsFile = "overlay-options"
+
+// rootless variant of mountImageOverlay, only prepares everything for MountRootFS
+func saveOverlayOptions(manifest *registry.DockerManifest, target string) error {
+	if err := createMountTargetDirs(target); err != nil {
+		return err
+	}
+	mountOptions := prepareOverlayMountOptions(manifest, target)
+	return ioutil.WriteFile(filepath.Join(target, overlayOptionsFile), []byte(mountOptions), 0644)
+}
+
+/*
+ MountRootFS mounts overlay of rootless container, it must run inside its user and mount namespace.
+ Kernel allows overlay there since 5.11, it needs userxattr option as trusted xattrs can't be used.
+ Older kernels fall back to fuse-overlayfs.
+*/
+func MountRootFS(containerPath string) error {
+	mountOptions, err := ioutil.ReadFile(filepath.Join(containerPath, overlayOptionsFile))
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(containerPath, "rootfs")
+	err = syscall.Mount("overlay", target, "overlay", 0, string(mountOptions)+",userxattr")
+	if err == nil {
+		return nil
+	}
+	fuse, lookErr := exec.LookPath("fuse-overlayfs")
+	if lookErr != nil {
+		return fmt.Errorf("overlay mount failed (%s) and fuse-overlayfs is not available", err)
+	}
+	if out, err := exec.Command(fuse, "-o", string(mountOptions), target).CombinedOutput(); err != nil {
+		return fmt.Errorf("fuse-overlayfs: %s %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
 // just ensure that target directories are there
 func createMountTargetDirs(target string) error {
 	if err := os.MkdirAll(filepath.Join(target, "rootfs"), 0755); err != nil {
@@ -66,7 +106,7 @@ func prepareOverlayMountOptions(manifest *registry.DockerManifest, target string
 	)
 
 	for i := len(manifest.Layers) - 1; i >= 0; i-- {
-		digests = append(digests, strings.Replace(filepath.Join(storageRootPath, "blobs", manifest.Layers[i].Digest), ":", "\\:", 1))
+		digests = append(digests, strings.Replace(layerPath(manifest.Layers[i].Digest), ":", "\\:", 1))
 	}
 	lowers = strings.Join(digests, ":")
 	return "lowerdir=" + lowers + ",upperdir=" + filepath.Join(target, "upper") + ",workdir=" + filepath.Join(target, "workdir")
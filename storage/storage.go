@@ -3,7 +3,9 @@ package storage
 import (
 	"archive/tar"
 	"compress/gzip"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,6 +14,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/odk-/dockerinternals/registry"
 
@@ -28,6 +31,10 @@ const AufsDeletedDirMark = ".wh..wh..opq"
 
 var (
 	storageRootPath = "/tmp/cme"
+	// unprivileged user can't mount overlay nor use trusted xattrs on host
+	rootless = false
+	// user namespace mappings of containers, see SetIDMappings
+	uidMappings, gidMappings []syscall.SysProcIDMap
 )
 
 // SetStorageRootPath configures root path used to store all data. Defaults to /tmp/cme
@@ -35,12 +42,37 @@ func SetStorageRootPath(path string) {
 	storageRootPath = path
 }
 
+// SetRootless switches storage into mode usable without root, overlay is not mounted
+// by CreateContainerRootFS then, nsInit does it with MountRootFS inside user namespace
+func SetRootless(enabled bool) {
+	rootless = enabled
+}
+
+// RootlessStorageRootPath returns default storage location for unprivileged user, $XDG_DATA_HOME/cntcli
+func RootlessStorageRootPath() string {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(os.Getenv("HOME"), ".local", "share")
+	}
+	return filepath.Join(dataHome, "cntcli")
+}
+
+// SetIDMappings configures user namespace mappings layers are unpacked for. Owners from layer
+// are shifted to host ids so they match inside container. Each mapping gets its own copy
+// of layers, see layerPath, so containers with other mappings never share owners
+func SetIDMappings(uids, gids []syscall.SysProcIDMap) {
+	uidMappings, gidMappings = uids, gids
+}
+
 // InitStorage checks if proper folder structure is present and creates it if needed
 /*
 proper structure looks like this:
 -storageRootPath
 |-manifests			<- jsons with name as base64 string from: registry URI + image name + tag
-|-blobs				<- image layers
+|-blobs				<- image layers, digest-<mapping> when unpacked for user namespace mappings
+|-configs			<- image configuration jsons named by digest
+|-volumes			<- named volumes data
+|-networks			<- bridge network definitions, name.json
 |-containers			<- containers will have their fs here
 ||-<container_name>
 |||-rootfs			<- mounted overlayfs
@@ -48,7 +80,7 @@ proper structure looks like this:
 |||-upper			<- top layer that will hold all changes to image
 */
 func InitStorage() error {
-	err := os.Mkdir(storageRootPath, 0755)
+	err := os.MkdirAll(storageRootPath, 0755)
 	if err != nil && os.IsNotExist(err) {
 		return err
 	}
@@ -64,6 +96,18 @@ func InitStorage() error {
 	if err != nil && os.IsNotExist(err) {
 		return err
 	}
+	err = os.Mkdir(storageRootPath+"/configs", 0755)
+	if err != nil && os.IsNotExist(err) {
+		return err
+	}
+	err = os.Mkdir(storageRootPath+"/volumes", 0755)
+	if err != nil && os.IsNotExist(err) {
+		return err
+	}
+	err = os.Mkdir(NetworksPath(), 0755)
+	if err != nil && os.IsNotExist(err) {
+		return err
+	}
 	return nil
 }
 
@@ -99,14 +143,80 @@ func CreateContainerRootFS(img *registry.Image, containerName string) (string, e
 		}
 	}
 	// most important part, this actually mounts merged overlay filesystem
-	containerPath := filepath.Join(storageRootPath, "containers", containerName)
-	err = mountImageOverlay(manifest, containerPath)
+	containerPath := ContainerPath(containerName)
+	if rootless {
+		err = saveOverlayOptions(manifest, containerPath)
+	} else {
+		err = mountImageOverlay(manifest, containerPath)
+	}
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 	return filepath.Join(containerPath, "rootfs"), nil
 }
 
+// CopyRootFS copies mounted container rootfs into dst, used to export image
+// for other runtimes. Hard links and fifos are kept, devices too when running as root
+func CopyRootFS(rootfs, dst string) error {
+	return copyDir(rootfs, dst)
+}
+
+// GetImageConfig returns image configuration (default user, env, command...) of already pulled image.
+// Configuration is downloaded on first use and kept on disk.
+func GetImageConfig(img *registry.Image) (*registry.ImageConfig, error) {
+	manifest, err := loadManifest(img)
+	if err != nil {
+		// manifest save could fail on pull, ask registry again
+		if manifest, err = registry.GetManifest(img); err != nil {
+			return nil, err
+		}
+	}
+	path := filepath.Join(storageRootPath, "configs", manifest.Config.Digest+".json")
+	if file, err := ioutil.ReadFile(path); err == nil {
+		var config = &registry.ImageConfig{}
+		if err := json.Unmarshal(file, config); err == nil {
+			return config, nil
+		}
+	}
+	config, err := registry.GetImageConfig(img, manifest.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	j, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, j, 0644); err != nil {
+		log.Println("Image config save failed: ", err)
+	}
+	return config, nil
+}
+
+// NetworksPath returns directory holding network definitions
+func NetworksPath() string {
+	return filepath.Join(storageRootPath, "networks")
+}
+
+// ContainerPath returns directory holding all files of given container
+func ContainerPath(containerName string) string {
+	return filepath.Join(storageRootPath, "containers", containerName)
+}
+
+// ListContainers returns names of all containers present in storage
+func ListContainers() ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(storageRootPath, "containers"))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
 // SaveManifest stores manifests on disk to speed up starting new containers
 func saveManifest(manifest *registry.DockerManifest, img *registry.Image) error {
 	f, err := os.OpenFile(storageRootPath+"/manifests/"+generateJSONName(img), os.O_CREATE|os.O_WRONLY, 0644)
@@ -148,12 +258,23 @@ func generateJSONName(img *registry.Image) string {
 // checkLayerPresence checks if layer is already on disk
 // it will check only dir presence, won't check integrity
 func checkLayerPresence(digest string) bool {
-	if _, err := os.Stat(filepath.Join(storageRootPath, "blobs", digest)); err != nil {
+	if _, err := os.Stat(layerPath(digest)); err != nil {
 		return false
 	}
 	return true
 }
 
+// layerPath returns directory layer is unpacked to. Owners are shifted by id mappings
+// on unpack, so layer unpacked for other mappings lives in other directory. Unprivileged
+// user can't shift owners and without mappings they are kept, both use plain digest
+func layerPath(digest string) string {
+	if os.Geteuid() != 0 || (len(uidMappings) == 0 && len(gidMappings) == 0) {
+		return filepath.Join(storageRootPath, "blobs", digest)
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprint(uidMappings, gidMappings)))
+	return filepath.Join(storageRootPath, "blobs", digest+"-"+hex.EncodeToString(sum[:6]))
+}
+
 /*
  downloadLayer gets layer from registry and unpacks it.
  This is a very important function and quite big one.
@@ -172,7 +293,8 @@ func downloadLayer(img *registry.Image, digest string, compression string) error
 	tr := tar.NewReader(gz)
 	log.Printf("Downloading and unpacking layer: %s\n", digest)
 	// create directory for layer
-	if err := os.MkdirAll(filepath.Join(storageRootPath, "blobs", digest), 0755); err != nil {
+	layer := layerPath(digest)
+	if err := os.MkdirAll(layer, 0755); err != nil {
 		return err
 	}
 	// handle each file from layer
@@ -186,7 +308,7 @@ func downloadLayer(img *registry.Image, digest string, compression string) error
 			return err
 		}
 		// set destination path for the file
-		dst := filepath.Join(storageRootPath, "blobs", digest, hdr.Name)
+		dst := filepath.Join(layer, hdr.Name)
 
 		// here we check type of each tar element and handle it in proper way
 		switch hdr.Typeflag {
@@ -196,6 +318,9 @@ func downloadLayer(img *registry.Image, digest string, compression string) error
 					return err
 				}
 			}
+			if err := chownMapped(dst, hdr); err != nil {
+				return err
+			}
 		case tar.TypeReg:
 			// regular file, can be possibly AUFS deletion (whiteout) mark
 			// docker saves info about deleted files in AUFS format so we need to convert it into overlay ones while unpacking
@@ -217,17 +342,23 @@ func downloadLayer(img *registry.Image, digest string, compression string) error
 				return err
 			}
 			f.Close()
+			if err := chownMapped(dst, hdr); err != nil {
+				return err
+			}
 		case tar.TypeSymlink:
 			err := os.Symlink(hdr.Linkname, dst)
 			if err != nil {
 				return err
 			}
+			if err := chownMapped(dst, hdr); err != nil {
+				return err
+			}
 		case tar.TypeLink:
 			// very naive security to not have hard links that point outside of container
 			if !strings.HasPrefix(dst, storageRootPath+"/blobs") {
 				return fmt.Errorf("invalid hardlink %q -> %q", dst, hdr.Linkname)
 			}
-			err := os.Link(filepath.Join(storageRootPath, "blobs", digest, hdr.Linkname), dst)
+			err := os.Link(filepath.Join(layer, hdr.Linkname), dst)
 			if err != nil {
 				return err
 			}
@@ -252,7 +383,12 @@ func checkIfDeleted(hdr *tar.Header, dst string) (bool, error) {
 
 	// if a directory is marked as opaque by the AUFS special file, we need to translate that to overlay
 	if fileName == AufsDeletedDirMark {
-		err := unix.Setxattr(filePath, "trusted.overlay.opaque", []byte{'y'}, 0)
+		// trusted xattrs need CAP_SYS_ADMIN on host, rootless overlay is mounted with userxattr
+		xattr := "trusted.overlay.opaque"
+		if rootless {
+			xattr = "user.overlay.opaque"
+		}
+		err := unix.Setxattr(filePath, xattr, []byte{'y'}, 0)
 		// don't write the file itself. It would show up in merged dir.
 		return false, err
 	}
@@ -265,7 +401,7 @@ func checkIfDeleted(hdr *tar.Header, dst string) (bool, error) {
 		if err := unix.Mknod(originalPath, unix.S_IFCHR, 0); err != nil {
 			return false, err
 		}
-		if err := os.Chown(originalPath, hdr.Uid, hdr.Gid); err != nil {
+		if err := chownMapped(originalPath, hdr); err != nil {
 			return false, err
 		}
 
@@ -275,3 +411,29 @@ func checkIfDeleted(hdr *tar.Header, dst string) (bool, error) {
 
 	return true, nil
 }
+
+// sets owner from tar header shifted by id mappings. Only root can give files away,
+// unprivileged user keeps everything as its own which is root in container anyway
+func chownMapped(path string, hdr *tar.Header) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	return os.Lchown(path, hostID(uidMappings, hdr.Uid), hostID(gidMappings, hdr.Gid))
+}
+
+// translates container id to host id. Ids outside of mappings belong to container root,
+// without mappings ids are kept as they are
+func hostID(maps []syscall.SysProcIDMap, id int) int {
+	if len(maps) == 0 {
+		return id
+	}
+	for _, m := range maps {
+		if id >= m.ContainerID && id < m.ContainerID+m.Size {
+			return m.HostID + id - m.ContainerID
+		}
+	}
+	if id != 0 {
+		return hostID(maps, 0)
+	}
+	return id
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestLayerPathPerMapping(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("owners are shifted only when running as root")
+	}
+	defer SetIDMappings(nil, nil)
+	defer SetStorageRootPath(storageRootPath)
+	SetStorageRootPath("/store")
+
+	var mappings = map[string][]syscall.SysProcIDMap{
+		"none":    nil,
+		"root":    {{ContainerID: 0, HostID: 0, Size: 1}},
+		"subuids": {{ContainerID: 0, HostID: 0, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65536}},
+		"shifted": {{ContainerID: 0, HostID: 100000, Size: 65536}},
+	}
+	seen := make(map[string]string)
+	for name, m := range mappings {
+		SetIDMappings(m, m)
+		path := layerPath("sha256:abc")
+		if other, ok := seen[path]; ok {
+			t.Errorf("For %s expecting own layer directory, got %s shared with %s", name, path, other)
+		}
+		seen[path] = name
+		if name == "none" && path != "/store/blobs/sha256:abc" {
+			t.Errorf("For %s expecting %s, got %s", name, "/store/blobs/sha256:abc", path)
+		}
+	}
+}
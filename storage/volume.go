@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// same rules as docker uses for volume names
+var volumeNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// VolumePath returns directory holding data of named volume
+func VolumePath(name string) string {
+	return filepath.Join(storageRootPath, "volumes", name)
+}
+
+// CreateVolume creates named volume. Creating already existing volume is not an error
+func CreateVolume(name string) error {
+	if !volumeNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid volume name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	return os.MkdirAll(VolumePath(name), 0755)
+}
+
+// ListVolumes returns names of all named volumes
+func ListVolumes() ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(storageRootPath, "volumes"))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// RemoveVolume deletes named volume with all its data
+func RemoveVolume(name string) error {
+	if !volumeNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid volume name %q", name)
+	}
+	if _, err := os.Stat(VolumePath(name)); err != nil {
+		return fmt.Errorf("no such volume: %s", name)
+	}
+	return os.RemoveAll(VolumePath(name))
+}
+
+// IsVolumeEmpty returns true if named volume holds no files
+func IsVolumeEmpty(name string) (bool, error) {
+	entries, err := ioutil.ReadDir(VolumePath(name))
+	if err != nil {
+		return false, err
+	}
+	return len(entries) == 0, nil
+}
+
+/*
+ PopulateVolume copies content of path inside mounted image rootfs into directory of named volume.
+ Docker does the same when empty volume is mounted over non empty directory of the image,
+ so for example database images get their initial files in fresh volume.
+*/
+func PopulateVolume(volumePath, rootfs, path string) error {
+	// image can have symlinks pointing anywhere, don't let them take us outside of rootfs
+	src, err := filepath.EvalSymlinks(filepath.Join(rootfs, path))
+	if err != nil || !strings.HasPrefix(src, rootfs+"/") {
+		// nothing to copy
+		return nil
+	}
+	fi, err := os.Stat(src)
+	if err != nil || !fi.IsDir() {
+		return nil
+	}
+	return copyDir(src, volumePath)
+}
+
+// recursive copy that keeps modes, symlinks, hard links, fifos and (if we are root) ownership and devices
+func copyDir(src, dst string) error {
+	// first copy of every inode with more links, later ones are linked to it
+	links := make(map[[2]uint64]string)
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		st, _ := fi.Sys().(*syscall.Stat_t)
+
+		if st != nil && !fi.IsDir() && st.Nlink > 1 {
+			key := [2]uint64{uint64(st.Dev), st.Ino}
+			if first, ok := links[key]; ok {
+				return os.Link(first, target)
+			}
+			links[key] = target
+		}
+
+		switch {
+		case fi.IsDir():
+			if err := os.MkdirAll(target, fi.Mode().Perm()); err != nil {
+				return err
+			}
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		case fi.Mode().IsRegular():
+			if err := copyFile(path, target, fi.Mode().Perm()); err != nil {
+				return err
+			}
+		case fi.Mode()&os.ModeNamedPipe != 0, fi.Mode()&os.ModeDevice != 0 && os.Geteuid() == 0:
+			if st == nil {
+				return nil
+			}
+			if err := syscall.Mknod(target, st.Mode, int(st.Rdev)); err != nil {
+				return err
+			}
+		default:
+			// sockets belong to process that created them, devices can be created only by root
+			return nil
+		}
+
+		if st != nil && os.Geteuid() == 0 {
+			if err := os.Lchown(target, int(st.Uid), int(st.Gid)); err != nil {
+				return err
+			}
+		}
+		// umask, MkdirAll and chown lose sticky and setuid bits (/tmp, sudo...)
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return os.Chmod(target, fi.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky))
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCopyDirKeepsModeBits(t *testing.T) {
+	src, err := ioutil.TempDir("", "src")
+	if err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir("", "dst")
+	if err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	defer os.RemoveAll(dst)
+
+	var cases = map[string]os.FileMode{
+		"tmp":          os.ModeDir | os.ModeSticky | 0777,
+		"shared":       os.ModeDir | os.ModeSetgid | 0775,
+		"bin/sudo":     os.ModeSetuid | 0755,
+		"bin/wall":     os.ModeSetgid | 0755,
+		"etc/shadow":   0640,
+		"home/private": os.ModeDir | 0700,
+	}
+	for name, mode := range cases {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal("Got error: ", err)
+		}
+		if mode.IsDir() {
+			err = os.Mkdir(path, 0755)
+		} else {
+			err = ioutil.WriteFile(path, nil, 0644)
+		}
+		// chmod is not subject to umask
+		if err == nil {
+			err = os.Chmod(path, mode)
+		}
+		if err != nil {
+			t.Fatal("Got error: ", err)
+		}
+	}
+	if err := copyDir(src, dst); err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	for name, mode := range cases {
+		fi, err := os.Stat(filepath.Join(dst, name))
+		if err != nil {
+			t.Errorf("For %s got error: %s", name, err)
+			continue
+		}
+		if fi.Mode() != mode {
+			t.Errorf("For %s expecting %v, got %v", name, mode, fi.Mode())
+		}
+	}
+}
+
+func TestCopyDirKeepsLinksAndDevices(t *testing.T) {
+	src, err := ioutil.TempDir("", "src")
+	if err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir("", "dst")
+	if err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	defer os.RemoveAll(dst)
+
+	if err := ioutil.WriteFile(filepath.Join(src, "perl"), []byte("#!"), 0755); err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	if err := os.Link(filepath.Join(src, "perl"), filepath.Join(src, "perl5.36")); err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(src, "initctl"), 0600); err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	// only root can create devices, copy skips them otherwise
+	root := os.Geteuid() == 0
+	if root {
+		null := filepath.Join(src, "null")
+		if err := syscall.Mknod(null, syscall.S_IFCHR|0666, int(unix.Mkdev(1, 3))); err != nil {
+			t.Fatal("Got error: ", err)
+		}
+		if err := os.Chmod(null, 0666); err != nil {
+			t.Fatal("Got error: ", err)
+		}
+	}
+	if err := copyDir(src, dst); err != nil {
+		t.Fatal("Got error: ", err)
+	}
+
+	perl, err := os.Stat(filepath.Join(dst, "perl"))
+	if err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	versioned, err := os.Stat(filepath.Join(dst, "perl5.36"))
+	if err != nil {
+		t.Fatal("Got error: ", err)
+	}
+	if !os.SameFile(perl, versioned) {
+		t.Error("Hard link copied as separate file")
+	}
+	var cases = map[string]os.FileMode{
+		"initctl": os.ModeNamedPipe | 0600,
+	}
+	if root {
+		cases["null"] = os.ModeDevice | os.ModeCharDevice | 0666
+	}
+	for name, mode := range cases {
+		fi, err := os.Lstat(filepath.Join(dst, name))
+		if err != nil {
+			t.Errorf("For %s got error: %s", name, err)
+			continue
+		}
+		if fi.Mode() != mode {
+			t.Errorf("For %s expecting %v, got %v", name, mode, fi.Mode())
+		}
+	}
+}